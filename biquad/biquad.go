@@ -61,3 +61,43 @@ func (b *BiQuad) DoBiQuad(xn float64) float64 {
 
 	return yn
 }
+
+// Bank holds one BiQuad per channel so interleaved audio keeps separate
+// delays for every channel.  All filters in a bank share coefficients.
+type Bank []BiQuad
+
+// NewBank returns numChannels copies of proto with flushed delays.
+func NewBank(proto BiQuad, numChannels int) Bank {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	b := make(Bank, numChannels)
+	for i := range b {
+		b[i] = proto
+		b[i].FlushDelays()
+	}
+	return b
+}
+
+// Process filters interleaved data in place, sending sample i to the
+// filter of channel i % len(b) and applying the wet and dry mix.
+func (b Bank) Process(data []float64) {
+	numChannels := len(b)
+	if numChannels == 0 {
+		return
+	}
+	for i := 0; i < len(data); i += numChannels {
+		for ch := 0; ch < numChannels && i+ch < len(data); ch++ {
+			f := &b[ch]
+			in := data[i+ch]
+			data[i+ch] = f.DoBiQuad(in)*f.C0 + in*f.D0
+		}
+	}
+}
+
+// FlushDelays flushes the delays of every channel.
+func (b Bank) FlushDelays() {
+	for i := range b {
+		b[i].FlushDelays()
+	}
+}
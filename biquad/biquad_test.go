@@ -0,0 +1,78 @@
+package biquad
+
+import "testing"
+
+// lowpass is a smoothing filter with a long enough tail to show state
+// carried from one sample to the next.
+var lowpass = BiQuad{A0: 0.2, A1: 0.4, A2: 0.2, B1: -0.5, B2: 0.3, C0: 1}
+
+func TestBankChannels(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  []float64
+		right []float64
+	}{
+		{"impulse left, silent right", []float64{1, 0, 0, 0, 0, 0}, []float64{0, 0, 0, 0, 0, 0}},
+		{"different signals", []float64{1, -1, 1, -1, 1, -1}, []float64{0.5, 0.5, 0, 0, -0.5, -0.5}},
+	}
+	for _, tt := range tests {
+		data := make([]float64, 0, 2*len(tt.left))
+		for i := range tt.left {
+			data = append(data, tt.left[i], tt.right[i])
+		}
+		NewBank(lowpass, 2).Process(data)
+
+		// each channel must come out as if it had been filtered alone
+		for ch, in := range [][]float64{tt.left, tt.right} {
+			want := append([]float64(nil), in...)
+			NewBank(lowpass, 1).Process(want)
+			for i := range want {
+				if got := data[2*i+ch]; got != want[i] {
+					t.Errorf("%s: channel %d sample %d = %g, want %g", tt.name, ch, i, got, want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestBankFlushDelays(t *testing.T) {
+	in := []float64{1, -0.5, 0.25, 0.75, -1, 0.5}
+	fresh := append([]float64(nil), in...)
+	NewBank(lowpass, 3).Process(fresh)
+
+	b := NewBank(lowpass, 3)
+	b.Process([]float64{1, 1, 1, -1, -1, -1, 0.3, 0.6, 0.9})
+	b.FlushDelays()
+	for ch, f := range b {
+		if f.XZ1 != 0 || f.XZ2 != 0 || f.YZ1 != 0 || f.YZ2 != 0 {
+			t.Errorf("channel %d: delays %+v left after FlushDelays", ch, f)
+		}
+	}
+	got := append([]float64(nil), in...)
+	b.Process(got)
+	for i := range fresh {
+		if got[i] != fresh[i] {
+			t.Errorf("sample %d after FlushDelays = %g, want %g", i, got[i], fresh[i])
+		}
+	}
+}
+
+func TestNewBank(t *testing.T) {
+	proto := lowpass
+	proto.XZ1, proto.YZ1 = 1, 1
+	for _, n := range []int{-1, 0, 1, 2, 8} {
+		b := NewBank(proto, n)
+		want := n
+		if want < 1 {
+			want = 1
+		}
+		if len(b) != want {
+			t.Errorf("NewBank(%d) has %d channels, want %d", n, len(b), want)
+		}
+		for ch, f := range b {
+			if f.XZ1 != 0 || f.YZ1 != 0 || f.A0 != proto.A0 || f.C0 != proto.C0 {
+				t.Errorf("NewBank(%d) channel %d = %+v", n, ch, f)
+			}
+		}
+	}
+}
@@ -7,47 +7,52 @@ import (
 	"github.com/go-audio/audio"
 )
 
-// BSF implements a butterworth low pass filter
+// BSF implements a butterworth band stop filter
 type BSF struct {
-	L    biquad.BiQuad
-	R    biquad.BiQuad
+	Bank biquad.Bank
 	Freq float64
+	Q    float64
 }
 
-// BandStop applies a butterworth low pass filter
+// BandStop applies a butterworth band stop filter
 func BandStop(buf *audio.FloatBuffer, freq float64, samplerate float64, q float64, channel int) {
-	l := BSF{}
-	l.updateCoefficients(samplerate, freq, q)
-	for i := 0; i < len(buf.Data); i++ {
-		in := buf.Data[i]
-		buf.Data[i] = l.L.DoBiQuad(buf.Data[i])*l.L.C0 + in*l.L.D0
-		// if channel == 2 {
-		// 	inR := buf.Data[i+1]
-		// 	buf.Data[i+1] = l.R.DoBiQuad(buf.Data[i+1])*l.R.C0 + inR*l.R.D0
-		// 	i++
-		// }
-	}
+	l := BSF{Freq: freq, Q: q}
+	l.Prepare(samplerate, channel)
+	l.ProcessBlock(buf)
 }
 
-// UpdateCoefficients --
-func (l *BSF) updateCoefficients(samplerate, freq, q float64) {
+// Prepare computes the coefficients for the given sample rate and sets up
+// one filter per channel.
+func (l *BSF) Prepare(samplerate float64, channels int) {
+	l.Bank = biquad.NewBank(l.coefficients(samplerate, l.Freq, l.Q), channels)
+}
+
+// ProcessBlock filters the interleaved buffer in place.
+func (l *BSF) ProcessBlock(buf *audio.FloatBuffer) {
+	l.Bank.Process(buf.Data)
+}
+
+// Reset flushes the filter delays.
+func (l *BSF) Reset() {
+	l.Bank.FlushDelays()
+}
+
+// Latency of a biquad is zero samples.
+func (l *BSF) Latency() int {
+	return 0
+}
+
+func (l *BSF) coefficients(samplerate, freq, q float64) biquad.BiQuad {
+	var b biquad.BiQuad
 	C := math.Tan(math.Pi * freq * (freq / q) / samplerate)
 	D := 2 * math.Cos((2*math.Pi*freq)/samplerate)
-	l.L.A0 = 1/1 + C
-	l.L.A1 = -l.L.A0 * D
-	l.L.A2 = l.L.A0
-	l.L.B1 = -l.L.A0 * D
-	l.L.B2 = l.L.A0 * (1 - C)
-
-	l.L.C0 = 1.0
-	l.L.D0 = 0.0
-
-	l.R.A0 = 1/1 + C
-	l.R.A1 = -l.L.A0 * D
-	l.R.A2 = l.L.A0
-	l.R.B1 = -l.L.A0 * D
-	l.R.B2 = l.L.A0 * (1 - C)
-
-	l.R.C0 = 1.0
-	l.R.D0 = 0.0
+	b.A0 = 1/1 + C
+	b.A1 = -b.A0 * D
+	b.A2 = b.A0
+	b.B1 = -b.A0 * D
+	b.B2 = b.A0 * (1 - C)
+
+	b.C0 = 1.0
+	b.D0 = 0.0
+	return b
 }
@@ -9,40 +9,48 @@ import (
 
 // HPF --
 type HPF struct {
-	L    biquad.BiQuad
-	R    biquad.BiQuad
+	Bank biquad.Bank
 	Freq float64
 }
 
 // HighPass applies a butterworth high pass filter
 func HighPass(buf *audio.FloatBuffer, freq float64, samplerate float64, channel int) {
-	l := HPF{}
-	l.updateCoefficients(samplerate, freq)
-	for i := 0; i < len(buf.Data); i++ {
-		in := buf.Data[i]
-		output := l.L.DoBiQuad(in)
-		buf.Data[i] = output*l.L.C0 + in*l.L.D0
-	}
+	l := HPF{Freq: freq}
+	l.Prepare(samplerate, channel)
+	l.ProcessBlock(buf)
 }
 
-// UpdateCoefficients --
-func (l *HPF) updateCoefficients(samplerate, freq float64) {
+// Prepare computes the coefficients for the given sample rate and sets up
+// one filter per channel.
+func (l *HPF) Prepare(samplerate float64, channels int) {
+	l.Bank = biquad.NewBank(l.coefficients(samplerate, l.Freq), channels)
+}
+
+// ProcessBlock filters the interleaved buffer in place.
+func (l *HPF) ProcessBlock(buf *audio.FloatBuffer) {
+	l.Bank.Process(buf.Data)
+}
+
+// Reset flushes the filter delays.
+func (l *HPF) Reset() {
+	l.Bank.FlushDelays()
+}
+
+// Latency of a biquad is zero samples.
+func (l *HPF) Latency() int {
+	return 0
+}
+
+func (l *HPF) coefficients(samplerate, freq float64) biquad.BiQuad {
+	var b biquad.BiQuad
 	C := math.Tan(freq / samplerate)
-	l.L.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
-	l.L.A1 = -2 * l.L.A0
-	l.L.A2 = l.L.A0
-	l.L.B1 = 2 * l.L.A0 * (math.Pow(C, 2) - 1)
-	l.L.B2 = l.L.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
-
-	l.L.C0 = 1.0
-	l.L.D0 = 0.0
-
-	l.R.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
-	l.R.A1 = -2 * l.L.A0
-	l.R.A2 = l.L.A0
-	l.R.B1 = 2 * l.L.A0 * (math.Pow(C, 2) - 1)
-	l.R.B2 = l.L.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
-
-	l.R.C0 = 1.0
-	l.R.D0 = 0.0
+	b.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
+	b.A1 = -2 * b.A0
+	b.A2 = b.A0
+	b.B1 = 2 * b.A0 * (math.Pow(C, 2) - 1)
+	b.B2 = b.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
+
+	b.C0 = 1.0
+	b.D0 = 0.0
+	return b
 }
@@ -9,44 +9,48 @@ import (
 
 // LPF implements a butterworth low pass filter
 type LPF struct {
-	L    biquad.BiQuad
-	R    biquad.BiQuad
+	Bank biquad.Bank
 	Freq float64
 }
 
 // LowPass applies a butterworth low pass filter
 func LowPass(buf *audio.FloatBuffer, freq float64, samplerate float64, channel int) {
-	l := LPF{}
-	l.updateCoefficients(samplerate, freq)
-	for i := 0; i < len(buf.Data); i++ {
-		in := buf.Data[i]
-		buf.Data[i] = l.L.DoBiQuad(buf.Data[i])*l.L.C0 + in*l.L.D0
-		// if channel == 2 {
-		// 	inR := buf.Data[i+1]
-		// 	buf.Data[i+1] = l.R.DoBiQuad(buf.Data[i+1])*l.R.C0 + inR*l.R.D0
-		// 	i++
-		// }
-	}
+	l := LPF{Freq: freq}
+	l.Prepare(samplerate, channel)
+	l.ProcessBlock(buf)
 }
 
-// UpdateCoefficients --
-func (l *LPF) updateCoefficients(samplerate, freq float64) {
+// Prepare computes the coefficients for the given sample rate and sets up
+// one filter per channel.
+func (l *LPF) Prepare(samplerate float64, channels int) {
+	l.Bank = biquad.NewBank(l.coefficients(samplerate, l.Freq), channels)
+}
+
+// ProcessBlock filters the interleaved buffer in place.
+func (l *LPF) ProcessBlock(buf *audio.FloatBuffer) {
+	l.Bank.Process(buf.Data)
+}
+
+// Reset flushes the filter delays.
+func (l *LPF) Reset() {
+	l.Bank.FlushDelays()
+}
+
+// Latency of a biquad is zero samples.
+func (l *LPF) Latency() int {
+	return 0
+}
+
+func (l *LPF) coefficients(samplerate, freq float64) biquad.BiQuad {
+	var b biquad.BiQuad
 	C := 1 / math.Tan(freq/samplerate)
-	l.L.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
-	l.L.A1 = 2 * l.L.A0
-	l.L.A2 = l.L.A0
-	l.L.B1 = 2 * l.L.A0 * (1 - math.Pow(C, 2))
-	l.L.B2 = l.L.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
-
-	l.L.C0 = 1.0
-	l.L.D0 = 0.0
-
-	l.R.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
-	l.R.A1 = 2 * l.L.A0
-	l.R.A2 = l.L.A0
-	l.R.B1 = 2 * l.L.A0 * (1 - math.Pow(C, 2))
-	l.R.B2 = l.L.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
-
-	l.R.C0 = 1.0
-	l.R.D0 = 0.0
+	b.A0 = 1 / (1 + math.Sqrt(2)*C + math.Pow(C, 2))
+	b.A1 = 2 * b.A0
+	b.A2 = b.A0
+	b.B1 = 2 * b.A0 * (1 - math.Pow(C, 2))
+	b.B2 = b.A0 * (1 - math.Sqrt(2)*C + math.Pow(C, 2))
+
+	b.C0 = 1.0
+	b.D0 = 0.0
+	return b
 }
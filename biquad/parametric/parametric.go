@@ -9,8 +9,7 @@ import (
 
 // Parametric eq
 type Parametric struct {
-	L    biquad.BiQuad
-	R    biquad.BiQuad
+	Bank biquad.Bank
 	Freq float64
 	Gain float64
 	Q    float64
@@ -18,16 +17,34 @@ type Parametric struct {
 
 // EQ applies a constant q parametric eq
 func EQ(buf *audio.FloatBuffer, freq float64, gain float64, q float64, samplerate float64, channel int) {
-	l := Parametric{}
-	l.updateCoefficients(samplerate, freq, gain, q)
-	for i := 0; i < len(buf.Data); i++ {
-		in := buf.Data[i]
-		output := l.L.DoBiQuad(in)
-		buf.Data[i] = output*l.L.C0 + in*l.L.D0
-	}
+	l := Parametric{Freq: freq, Gain: gain, Q: q}
+	l.Prepare(samplerate, channel)
+	l.ProcessBlock(buf)
+}
+
+// Prepare computes the coefficients for the given sample rate and sets up
+// one filter per channel.
+func (p *Parametric) Prepare(samplerate float64, channels int) {
+	p.Bank = biquad.NewBank(p.coefficients(samplerate, p.Freq, p.Gain, p.Q), channels)
 }
 
-func (p *Parametric) updateCoefficients(samplerate, freq, gain, q float64) {
+// ProcessBlock filters the interleaved buffer in place.
+func (p *Parametric) ProcessBlock(buf *audio.FloatBuffer) {
+	p.Bank.Process(buf.Data)
+}
+
+// Reset flushes the filter delays.
+func (p *Parametric) Reset() {
+	p.Bank.FlushDelays()
+}
+
+// Latency of a biquad is zero samples.
+func (p *Parametric) Latency() int {
+	return 0
+}
+
+func (p *Parametric) coefficients(samplerate, freq, gain, q float64) biquad.BiQuad {
+	var b biquad.BiQuad
 	K := math.Tan((math.Pi * freq) / samplerate)
 	V0 := math.Pow(10, (gain / 20))
 	D0 := 1 + ((1 / q) * K) + math.Pow(K, 2)
@@ -40,39 +57,22 @@ func (p *Parametric) updateCoefficients(samplerate, freq, gain, q float64) {
 
 	if gain >= 0.0 {
 		// Boost
-		p.L.A0 = A / D0
-		p.L.A1 = B / D0
-		p.L.A2 = G / D0
-		p.L.B1 = B / D0
-		p.L.B2 = D / D0
-		p.L.C0 = 1.0
-		p.L.D0 = 0.0
-
-		p.R.A0 = A / D0
-		p.R.A1 = B / D0
-		p.R.A2 = G / D0
-		p.R.B1 = B / D0
-		p.R.B2 = D / D0
-		p.R.C0 = 1.0
-		p.R.D0 = 0.0
+		b.A0 = A / D0
+		b.A1 = B / D0
+		b.A2 = G / D0
+		b.B1 = B / D0
+		b.B2 = D / D0
 	} else {
 		// Cut
-		p.L.A0 = D0 / E0
-		p.L.A1 = B / E0
-		p.L.A2 = D / E0
-		p.L.B1 = B / E0
-		p.L.B2 = E / E0
-		p.L.C0 = 1.0
-		p.L.D0 = 0.0
-
-		p.R.A0 = D0 / E0
-		p.R.A1 = B / E0
-		p.R.A2 = D / E0
-		p.R.B1 = B / E0
-		p.R.B2 = E / E0
-		p.R.C0 = 1.0
-		p.R.D0 = 0.0
+		b.A0 = D0 / E0
+		b.A1 = B / E0
+		b.A2 = D / E0
+		b.B1 = B / E0
+		b.B2 = E / E0
 	}
+	b.C0 = 1.0
+	b.D0 = 0.0
+	return b
 }
 
 // func (p *Parametric) updateNotConstantQ(samplerate float64) {
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"soxy/loudness"
+)
+
+func cmdAnalyze(args []string) int {
+	fs := newFlagSet("analyze")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		m, err := measure(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("Filename:\t%s\nIntegrated:\t%.1f LUFS\nRange:\t\t%.1f LU\nSample peak:\t%.2f dBFS\nTrue peak:\t%.2f dBTP\n",
+			path, m.Integrated(), m.Range(), m.SamplePeak(), m.TruePeak())
+	}
+	return status
+}
+
+// measure runs a whole file through a loudness meter.
+func measure(path string) (*loudness.Meter, error) {
+	w, f, err := openWav(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := loudness.NewMeter(float64(w.SampleRate), int(w.NumChans))
+	buf := make([]float64, blockSize*int(w.NumChans))
+	for {
+		n, err := w.ReadFloats(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if n == 0 {
+			return m, nil
+		}
+		m.Write(buf[:n])
+	}
+}
+
+func cmdDiff(args []string) int {
+	fs := newFlagSet("diff")
+	tolerance := fs.Float64("tolerance", -150, "largest difference in dBFS that still counts as the same")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	same, err := diff(fs.Arg(0), fs.Arg(1), *tolerance)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !same {
+		return 1
+	}
+	return 0
+}
+
+// diff prints how far apart the audio of two files is and reports whether
+// every sample is within tolerance dBFS.  Files with a different channel
+// count or rate are never the same; the bit depth may differ.
+func diff(a, b string, tolerance float64) (bool, error) {
+	ra, fa, err := openWav(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	rb, fb, err := openWav(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	if ra.NumChans != rb.NumChans || ra.SampleRate != rb.SampleRate {
+		fmt.Printf("format differs: %d channels at %d Hz vs %d channels at %d Hz\n",
+			ra.NumChans, ra.SampleRate, rb.NumChans, rb.SampleRate)
+		return false, nil
+	}
+
+	bufA := make([]float64, blockSize*int(ra.NumChans))
+	bufB := make([]float64, len(bufA))
+	var frames, extra int64
+	var max, sum float64
+	for {
+		na, err := ra.ReadFloats(bufA)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", a, err)
+		}
+		nb, err := rb.ReadFloats(bufB)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", b, err)
+		}
+		n := na
+		if nb < n {
+			n = nb
+		}
+		for i := 0; i < n; i++ {
+			d := math.Abs(bufA[i] - bufB[i])
+			max = math.Max(max, d)
+			sum += d * d
+		}
+		frames += int64(n / int(ra.NumChans))
+		extra += int64((na + nb - 2*n) / int(ra.NumChans))
+		if na == 0 || nb == 0 {
+			// drain whichever file is longer
+			for {
+				r, buf := ra, bufA
+				if na == 0 {
+					r, buf = rb, bufB
+				}
+				k, err := r.ReadFloats(buf)
+				if err != nil || k == 0 {
+					break
+				}
+				extra += int64(k / int(ra.NumChans))
+			}
+			break
+		}
+	}
+
+	if extra != 0 {
+		fmt.Printf("length differs by %d frames\n", extra)
+	}
+	rms := 0.0
+	if frames > 0 {
+		rms = math.Sqrt(sum / float64(frames*int64(ra.NumChans)))
+	}
+	fmt.Printf("frames compared:\t%d\nmax difference:\t%.1f dBFS\nrms difference:\t%.1f dBFS\n",
+		frames, 20*math.Log10(max), 20*math.Log10(rms))
+	return extra == 0 && 20*math.Log10(max) <= tolerance, nil
+}
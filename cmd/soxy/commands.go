@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"soxy/pipeline"
+	"soxy/wavio"
+	"syscall"
+)
+
+// blockSize is the number of frames read from a file at a time, the same
+// as the pipeline.
+const blockSize = pipeline.BlockSize
+
+// command is a soxy sub command.
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(args []string) int
+}
+
+// commands is filled in by init, the commands refer back to it for help.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"process", "-c config -inPath dir -outPath dir | -in file -out file", "render a batch of files or one file with a config", cmdProcess},
+		{"info", "file|folder...", "print the format, levels and loudness of WAV files", cmdInfo},
+		{"analyze", "file...", "measure loudness and peaks", cmdAnalyze},
+		{"diff", "a.wav b.wav", "compare the audio of two files", cmdDiff},
+		{"gen", "out.wav", "write a test signal", cmdGen},
+		{"watch", "-c config -inPath dir -outPath dir", "process waves as they appear in a folder", cmdWatch},
+		{"serve", "[-addr host:port] [-presets dir]", "run an HTTP API that processes files", cmdServe},
+		{"help", "[command]", "show help for a command", cmdHelp},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns the flags of the named command with a usage message
+// built from its entry in commands.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		c, _ := findCommand(name)
+		fmt.Fprintf(fs.Output(), "usage: soxy %s %s\n\n%s.\n\nflags:\n", c.name, c.args, c.short)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: soxy <command> [flags] [args]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(out, "\nRun soxy help <command> for its flags.  Without a command the flags of\nprocess are accepted, and -i file is the same as soxy info file.\n\nprocess flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	if len(os.Args) == 1 {
+		usage()
+		os.Exit(2)
+	}
+	if c, ok := findCommand(os.Args[1]); ok {
+		os.Exit(c.run(os.Args[2:]))
+	}
+
+	// the flat flags of earlier versions
+	info := flag.String("i", "", "get info about the file and any files after the flags (same as soxy info)")
+	asJSON := flag.Bool("json", false, "with -i, print one JSON object per file")
+	addProcessFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+	if *info != "" {
+		os.Exit(runInfo(append([]string{*info}, flag.Args()...), *asJSON, recursive))
+	}
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "soxy: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(runProcess())
+}
+
+func cmdProcess(args []string) int {
+	fs := newFlagSet("process")
+	addProcessFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	return runProcess()
+}
+
+func cmdHelp(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 0
+	}
+	c, ok := findCommand(args[0])
+	if !ok || c.name == "help" {
+		usage()
+		return 2
+	}
+	return c.run([]string{"-h"})
+}
+
+// interruptContext returns a context that is cancelled by SIGINT or
+// SIGTERM, so a run can remove its temp files and partial outputs and say
+// what it got done.  After the first signal a second one kills soxy the
+// usual way, in case cleaning up hangs.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// openWav opens a WAV file, or stdin for "-", and reads its header.  The
+// caller closes f.
+func openWav(path string) (*wavio.Reader, *os.File, error) {
+	f := os.Stdin
+	if path != stdio {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	w, err := wavio.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return w, f, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patterns is a flag.Value holding comma separated glob patterns.  It can
+// be given more than once.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, err := filepath.Match(s, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %v", s, err)
+		}
+		*p = append(*p, s)
+	}
+	return nil
+}
+
+// matches reports whether any pattern matches rel, a slash separated path
+// relative to the input folder.  Patterns containing a slash are matched
+// against the whole path, others against the last element only.
+func (p patterns) matches(rel string) bool {
+	for _, pat := range p {
+		name := rel
+		if !strings.Contains(pat, "/") {
+			name = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if ok, _ := filepath.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// samePath reports whether a and b name the same file or folder.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// discover lists the files under root that match include and none of
+// exclude, as paths relative to root.  Sub folders are searched when
+// recursive is set; excluded folders and skip (the output folder, when it
+// lives inside root) are not entered.
+func discover(root string, recursive bool, include, exclude patterns, skip string) ([]string, error) {
+	if skip != "" {
+		skip, _ = filepath.Abs(skip)
+	}
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			// checked before the root is let through: skip can be root
+			if abs, _ := filepath.Abs(path); abs == skip {
+				return filepath.SkipDir
+			}
+			if rel == "." {
+				return nil
+			}
+			if !recursive || exclude.matches(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if include.matches(rel) && !exclude.matches(rel) {
+			files = append(files, filepath.FromSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
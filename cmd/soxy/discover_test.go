@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"soxy/pipeline"
+	"testing"
+)
+
+func TestPatternsMatches(t *testing.T) {
+	tests := []struct {
+		pats patterns
+		rel  string
+		want bool
+	}{
+		{patterns{"*.wav"}, "a.wav", true},
+		{patterns{"*.wav"}, "sub/a.wav", true},
+		{patterns{"*.wav"}, "a.WAV", false},
+		{patterns{"*.wav", "*.WAV"}, "a.WAV", true},
+		{patterns{"sub/*.wav"}, "sub/a.wav", true},
+		{patterns{"sub/*.wav"}, "other/a.wav", false},
+		{patterns{"sub/*.wav"}, "a.wav", false},
+		{patterns{"tmp"}, "sub/tmp", true},
+		{nil, "a.wav", false},
+	}
+	for _, tt := range tests {
+		if got := tt.pats.matches(tt.rel); got != tt.want {
+			t.Errorf("%v.matches(%q) = %v, want %v", tt.pats, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestPatternsSet(t *testing.T) {
+	var p patterns
+	if err := p.Set("*.wav, *.WAV,"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("sub/*"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (patterns{"*.wav", "*.WAV", "sub/*"}); !reflect.DeepEqual(p, want) {
+		t.Errorf("got %v, want %v", p, want)
+	}
+	if err := p.Set("[a"); err == nil {
+		t.Error("[a: expected an error")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, name := range []string{"a.wav", "b.txt", "take.tmp.wav", "sub/c.wav", "sub/deep/d.wav", "skip/e.wav", "out/f.wav"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		recursive bool
+		include   patterns
+		exclude   patterns
+		skip      string
+		want      []string
+	}{
+		{"flat", false, patterns{"*.wav"}, nil, "", []string{"a.wav", "take.tmp.wav"}},
+		{"recursive", true, patterns{"*.wav"}, nil, "", []string{"a.wav", "out/f.wav", "skip/e.wav", "sub/c.wav", "sub/deep/d.wav", "take.tmp.wav"}},
+		{"exclude file", true, patterns{"*.wav"}, patterns{"*.tmp.wav"}, "", []string{"a.wav", "out/f.wav", "skip/e.wav", "sub/c.wav", "sub/deep/d.wav"}},
+		{"exclude folder", true, patterns{"*.wav"}, patterns{"skip", "sub/deep"}, "", []string{"a.wav", "out/f.wav", "sub/c.wav", "take.tmp.wav"}},
+		{"include path", true, patterns{"sub/*.wav"}, nil, "", []string{"sub/c.wav"}},
+		{"skip outPath", true, patterns{"*.wav"}, nil, filepath.Join(root, "out"), []string{"a.wav", "skip/e.wav", "sub/c.wav", "sub/deep/d.wav", "take.tmp.wav"}},
+		{"other files", false, patterns{"*.txt"}, nil, "", []string{"b.txt"}},
+		{"skip root", true, patterns{"*.wav"}, nil, root + string(filepath.Separator), nil},
+	}
+	for _, tt := range tests {
+		got, err := discover(root, tt.recursive, tt.include, tt.exclude, tt.skip)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var want []string
+		for _, w := range tt.want {
+			want = append(want, filepath.FromSlash(w))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+
+	if _, err := discover(filepath.Join(root, "missing"), false, patterns{"*.wav"}, nil, ""); err == nil {
+		t.Error("missing root: expected an error")
+	}
+
+	// outputs written over their inputs
+	for _, out := range []string{root, root + "/", filepath.Join(root, "sub", "..")} {
+		b := batch{InPath: root, OutPath: out, Include: patterns{"*.wav"}}
+		if _, err := b.jobs(pipeline.Config{}); err == nil {
+			t.Errorf("outPath %s: expected an error", out)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"os"
+	"soxy/pipeline"
+	"sync"
+	"time"
+)
+
+// Events of the -progress stream.
+const (
+	evBatchStarted  = "batch_started"
+	evFileStarted   = "file_started"
+	evStageStarted  = "stage_started"
+	evStageFinished = "stage_finished"
+	evFileDone      = "file_done"
+	evFileSkipped   = "file_skipped"
+	evFileFailed    = "file_failed"
+	evFileCancelled = "file_cancelled"
+	evBatchFinished = "batch_finished"
+)
+
+// event is one line of the -progress stream.
+type event struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	File   string    `json:"file,omitempty"`
+	Output string    `json:"output,omitempty"`
+	Stage  string    `json:"stage,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	// Seconds is how long the stage, file or batch took.
+	Seconds *float64 `json:"seconds,omitempty"`
+	Gains   *gains   `json:"gains,omitempty"`
+	// Notes are the warnings about a processed file.
+	Notes  []string `json:"notes,omitempty"`
+	Counts *counts  `json:"counts,omitempty"`
+}
+
+// gains are the normalization gains applied to a file in dB, 0 when the
+// config doesn't ask for them.
+type gains struct {
+	Loudness float64 `json:"loudness"`
+	Peak     float64 `json:"peak"`
+}
+
+// counts describe a batch.
+type counts struct {
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+// eventLog writes events as JSON lines.  Its methods do nothing on a nil
+// *eventLog, so callers needn't check whether -progress was given.
+type eventLog struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// progress is the -progress stream, nil without the flag.
+var progress *eventLog
+
+// openEvents opens the event stream at path, "-" for stdout.
+func openEvents(path string) (*eventLog, error) {
+	var w io.WriteCloser = os.Stdout
+	if path != stdio {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &eventLog{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// emit writes e stamped with the current time.
+func (l *eventLog) emit(e event) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		log.Print(err)
+	}
+}
+
+// stages returns a pipeline.StageFunc writing the stage events of file as
+// they happen, or nil.
+func (l *eventLog) stages(file string) pipeline.StageFunc {
+	if l == nil {
+		return nil
+	}
+	return func(stage string, finished bool, took time.Duration) {
+		if finished {
+			l.emit(event{Event: evStageFinished, File: file, Stage: stage, Seconds: seconds(took)})
+		} else {
+			l.emit(event{Event: evStageStarted, File: file, Stage: stage})
+		}
+	}
+}
+
+// fileDone writes how a job ended.
+func (l *eventLog) fileDone(j job, r result, report pipeline.Report, took time.Duration) {
+	if l == nil {
+		return
+	}
+	e := event{File: r.File, Seconds: seconds(took)}
+	switch {
+	case r.Canceled:
+		e.Event = evFileCancelled
+	case r.Err != nil:
+		f := toFailure(r)
+		e.Event, e.Stage, e.Error = evFileFailed, f.Stage, f.Error
+	case r.Skipped:
+		e.Event, e.Output = evFileSkipped, j.OutFile
+	default:
+		e.Event, e.Output = evFileDone, j.OutFile
+		e.Gains = &gains{Loudness: round2(report.LoudnessGain), Peak: round2(report.PeakGain)}
+		e.Notes = report.Notes
+	}
+	l.emit(e)
+}
+
+// batchFinished writes the counts of a finished batch of total files.
+func (l *eventLog) batchFinished(total int, results []result, took time.Duration) {
+	if l == nil {
+		return
+	}
+	c := counts{Total: total}
+	for _, r := range results {
+		switch {
+		case r.Canceled:
+			c.Cancelled++
+		case r.Err != nil:
+			c.Failed++
+		case r.Skipped:
+			c.Skipped++
+		default:
+			c.Processed++
+		}
+	}
+	l.emit(event{Event: evBatchFinished, Seconds: seconds(took), Counts: &c})
+}
+
+func (l *eventLog) Close() error {
+	if l == nil || l.w == os.Stdout {
+		return nil
+	}
+	return l.w.Close()
+}
+
+// seconds rounds d to milliseconds for an event.
+func seconds(d time.Duration) *float64 {
+	s := math.Round(d.Seconds()*1000) / 1000
+	return &s
+}
+
+// round2 rounds a gain to 0.01 dB.
+func round2(db float64) float64 {
+	return math.Round(db*100) / 100
+}
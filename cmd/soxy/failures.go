@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"soxy/pipeline"
+)
+
+// Stages reported with a failure besides the ones of the pipeline.
+const (
+	stageOpen  = "open"
+	stageStats = "stats"
+)
+
+// failuresFile is written to the output folder when files fail.
+const failuresFile = "failures.json"
+
+// stageError wraps err as a *pipeline.Error naming file unless it already
+// is one.
+func stageError(file, stage string, err error) error {
+	if pe, ok := err.(*pipeline.Error); ok {
+		if pe.File == "" {
+			pe.File = file
+		}
+		return pe
+	}
+	return &pipeline.Error{File: file, Stage: stage, Err: err}
+}
+
+// result is the outcome of one job.
+type result struct {
+	File string
+	Rel  string
+	// Key is the output's entry in the state.
+	Key string
+	Err error
+	// Skipped is set when a resumed run found the output up to date.
+	Skipped bool
+	// Canceled is set when the run was interrupted while the file was
+	// processed.  Err holds why, but the file didn't fail.
+	Canceled bool
+	// State is what the output was made from.
+	State fileState
+	// Memory is the footprint of the job, returned to the budget.
+	Memory int64
+	// Notes are the lines to log about a processed file, kept until no
+	// progress bar is drawn.
+	Notes []string
+}
+
+// failure is the JSON form of a failed result.
+type failure struct {
+	File  string `json:"file"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// toFailure returns the JSON form of a failed result.
+func toFailure(r result) failure {
+	f := failure{File: r.File, Stage: "process", Error: r.Err.Error()}
+	if pe, ok := r.Err.(*pipeline.Error); ok {
+		f.Stage, f.Error = pe.Stage, pe.Err.Error()
+	}
+	return f
+}
+
+// summarize prints how the batch went to w and, when any file failed,
+// writes the failures to failuresFile in outPath.  It returns the number
+// of failed files.  Cancelled files are counted as neither processed nor
+// failed.
+func summarize(w io.Writer, results []result, outPath string) (int, error) {
+	var failures []failure
+	skipped, canceled := 0, 0
+	for _, r := range results {
+		switch {
+		case r.Canceled:
+			canceled++
+		case r.Skipped:
+			skipped++
+		case r.Err != nil:
+			failures = append(failures, toFailure(r))
+		}
+	}
+	fmt.Fprintf(w, "%d files processed, %d up to date, %d failed\n", len(results)-len(failures)-skipped-canceled, skipped, len(failures))
+	path := filepath.Join(outPath, failuresFile)
+	if len(failures) == 0 {
+		// don't leave the failures of an earlier run behind
+		os.Remove(path)
+		return 0, nil
+	}
+	for _, f := range failures {
+		fmt.Fprintf(w, "  %s: %s: %s\n", f.File, f.Stage, f.Error)
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return len(failures), err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return len(failures), err
+	}
+	fmt.Fprintf(w, "failures written to %s\n", path)
+	return len(failures), nil
+}
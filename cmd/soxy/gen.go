@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"soxy/dither"
+	"soxy/wavio"
+	"time"
+)
+
+func cmdGen(args []string) int {
+	fs := newFlagSet("gen")
+	kind := fs.String("type", "sine", "signal: sine, square, noise, silence or sweep")
+	freq := fs.Float64("freq", 1000, "frequency in Hz, or the start of a sweep")
+	to := fs.Float64("to", 20000, "end frequency of a sweep in Hz")
+	level := fs.Float64("level", -20, "peak level in dBFS")
+	duration := fs.Duration("duration", time.Second, "length of the signal")
+	rate := fs.Int("rate", 48000, "sample rate")
+	bits := fs.Int("bits", 24, "bit depth")
+	float := fs.Bool("float", false, "write IEEE float samples")
+	channels := fs.Int("channels", 1, "number of channels, all carrying the same signal")
+	seed := fs.Int64("seed", 1, "seed for noise and dither")
+	ditherKind := fs.String("dither", dither.None, "dither for integer output: none or tpdf")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if err := gen(fs.Arg(0), *kind, *freq, *to, *level, *duration, *rate, *bits, *float, *channels, *seed, *ditherKind); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// gen writes a test signal to path.
+func gen(path, kind string, freq, to, level float64, duration time.Duration, rate, bits int, float bool, channels int, seed int64, ditherKind string) error {
+	if rate <= 0 || channels <= 0 {
+		return fmt.Errorf("gen: need a positive rate and channel count")
+	}
+	amp := math.Pow(10, level/20)
+	rng := rand.New(rand.NewSource(seed))
+	frames := int(duration.Seconds() * float64(rate))
+	var sample func(i int) float64
+	switch kind {
+	case "sine":
+		sample = func(i int) float64 { return amp * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)) }
+	case "square":
+		sample = func(i int) float64 {
+			if math.Sin(2*math.Pi*freq*float64(i)/float64(rate)) < 0 {
+				return -amp
+			}
+			return amp
+		}
+	case "noise":
+		sample = func(int) float64 { return amp * (2*rng.Float64() - 1) }
+	case "silence":
+		sample = func(int) float64 { return 0 }
+	case "sweep":
+		// exponential sweep, the phase is the integral of the frequency
+		if freq <= 0 || to <= 0 || freq == to {
+			return fmt.Errorf("gen: a sweep needs two different frequencies above 0")
+		}
+		k := math.Log(to / freq)
+		length := duration.Seconds()
+		sample = func(i int) float64 {
+			t := float64(i) / float64(rate)
+			phase := 2 * math.Pi * freq * length / k * (math.Exp(t/length*k) - 1)
+			return amp * math.Sin(phase)
+		}
+	default:
+		return fmt.Errorf("gen: unknown type %q (want sine, square, noise, silence or sweep)", kind)
+	}
+
+	format := uint16(wavio.FormatPCM)
+	if float {
+		format = wavio.FormatIEEEFloat
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w, err := wavio.NewWriter(out, rate, bits, channels, format)
+	if err != nil {
+		return err
+	}
+	var q *dither.Quantizer
+	if !float {
+		if q, err = dither.New(bits, channels, ditherKind, "", seed); err != nil {
+			return err
+		}
+	}
+
+	data := make([]float64, blockSize*channels)
+	ints := make([]int, len(data))
+	for start := 0; start < frames; start += blockSize {
+		n := frames - start
+		if n > blockSize {
+			n = blockSize
+		}
+		for i := 0; i < n; i++ {
+			v := sample(start + i)
+			for c := 0; c < channels; c++ {
+				data[i*channels+c] = v
+			}
+		}
+		if q == nil {
+			err = w.WriteFloats(data[:n*channels])
+		} else {
+			q.Quantize(data[:n*channels], ints[:n*channels])
+			err = w.WriteInts(ints[:n*channels])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"soxy/loudness"
+	"soxy/wavio"
+	"strings"
+	"time"
+)
+
+// fileInfo is what info reports about a file.  Levels are in dBFS where a
+// full scale sine peaks at 0.
+type fileInfo struct {
+	File       string         `json:"file"`
+	Format     string         `json:"format"`
+	FormatCode uint16         `json:"formatCode"`
+	Extensible bool           `json:"extensible"`
+	Channels   int            `json:"channels"`
+	SampleRate int            `json:"sampleRate"`
+	BitDepth   int            `json:"bitDepth"`
+	Frames     int64          `json:"frames"`
+	Duration   float64        `json:"duration"`
+	Chunks     []chunkInfo    `json:"chunks"`
+	Peak       level          `json:"peak"`
+	RMS        level          `json:"rms"`
+	PerChannel []channelStats `json:"perChannel"`
+	// Loudness is the integrated loudness in LUFS.
+	Loudness level `json:"loudness"`
+}
+
+// fileError takes the place of a fileInfo for a file that can't be read.
+type fileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+type chunkInfo struct {
+	ID   string `json:"id"`
+	Size uint32 `json:"size"`
+}
+
+type channelStats struct {
+	Peak level `json:"peak"`
+	RMS  level `json:"rms"`
+	// DCOffset is the mean sample value, 1.0 being full scale.
+	DCOffset float64 `json:"dcOffset"`
+}
+
+// level is a value in dB.  Silence is -Inf, which JSON can't hold, so it
+// is written as null.
+type level float64
+
+func (l level) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(math.Round(float64(l)*100) / 100)
+}
+
+func (l level) String() string {
+	return fmt.Sprintf("%.2f", float64(l))
+}
+
+func toDB(v float64) level {
+	return level(20 * math.Log10(v))
+}
+
+func cmdInfo(args []string) int {
+	fs := newFlagSet("info")
+	asJSON := fs.Bool("json", false, "print one JSON object per file")
+	recursive := fs.Bool("recursive", false, "also look in the sub folders of folder arguments")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	return runInfo(fs.Args(), *asJSON, *recursive)
+}
+
+// runInfo reports on every file in paths.  Folders are expanded to the
+// *.wav files in them.
+func runInfo(paths []string, asJSON, recursive bool) int {
+	var files []string
+	status := 0
+	for _, path := range paths {
+		st, err := os.Stat(path)
+		if err != nil || !st.IsDir() {
+			// inspect reports a missing file
+			files = append(files, path)
+			continue
+		}
+		found, err := discover(path, recursive, patterns{"*.wav", "*.WAV"}, nil, "")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+		for _, f := range found {
+			files = append(files, filepath.Join(path, f))
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for i, file := range files {
+		info, err := inspect(file)
+		if err != nil {
+			status = 1
+			if !asJSON {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			enc.Encode(fileError{File: file, Error: err.Error()})
+			continue
+		}
+		if asJSON {
+			enc.Encode(info)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printInfo(info)
+	}
+	return status
+}
+
+// inspect reads a whole file to measure it.
+func inspect(path string) (fileInfo, error) {
+	w, f, err := openWav(path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	defer f.Close()
+
+	channels := int(w.NumChans)
+	info := fileInfo{
+		File:       path,
+		Format:     "pcm",
+		FormatCode: w.WavAudioFormat,
+		Extensible: w.Extensible,
+		Channels:   channels,
+		SampleRate: int(w.SampleRate),
+		BitDepth:   int(w.BitDepth),
+	}
+	if w.WavAudioFormat == wavio.FormatIEEEFloat {
+		info.Format = "float"
+	}
+
+	m := loudness.NewMeter(float64(w.SampleRate), channels)
+	peak := make([]float64, channels)
+	sum := make([]float64, channels)
+	sumSq := make([]float64, channels)
+	buf := make([]float64, blockSize*channels)
+	for {
+		n, err := w.ReadFloats(buf)
+		if err != nil {
+			return fileInfo{}, fmt.Errorf("%s: %v", path, err)
+		}
+		if n == 0 {
+			break
+		}
+		m.Write(buf[:n])
+		for i, x := range buf[:n] {
+			c := i % channels
+			peak[c] = math.Max(peak[c], math.Abs(x))
+			sum[c] += x
+			sumSq[c] += x * x
+		}
+		info.Frames += int64(n / channels)
+	}
+	if err := w.ReadTrailingChunks(); err != nil {
+		return fileInfo{}, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, c := range w.Chunks {
+		info.Chunks = append(info.Chunks, chunkInfo{ID: c.ID, Size: c.Size})
+	}
+
+	info.Duration = float64(info.Frames) / float64(w.SampleRate)
+	frames := math.Max(float64(info.Frames), 1)
+	maxPeak, totalSq := 0.0, 0.0
+	for c := 0; c < channels; c++ {
+		info.PerChannel = append(info.PerChannel, channelStats{
+			Peak:     toDB(peak[c]),
+			RMS:      toDB(math.Sqrt(sumSq[c] / frames)),
+			DCOffset: sum[c] / frames,
+		})
+		maxPeak = math.Max(maxPeak, peak[c])
+		totalSq += sumSq[c]
+	}
+	info.Peak = toDB(maxPeak)
+	info.RMS = toDB(math.Sqrt(totalSq / frames / float64(channels)))
+	info.Loudness = level(m.Integrated())
+	return info, nil
+}
+
+// printInfo basic replacement for soxi - lets you peek metdata
+func printInfo(info fileInfo) {
+	var chunks []string
+	for _, c := range info.Chunks {
+		chunks = append(chunks, fmt.Sprintf("%s (%d)", strings.TrimSpace(c.ID), c.Size))
+	}
+	format := fmt.Sprintf("%s (0x%04x)", info.Format, info.FormatCode)
+	if info.Extensible {
+		format += ", extensible"
+	}
+	var peaks, rms, dc []string
+	for _, c := range info.PerChannel {
+		peaks = append(peaks, c.Peak.String())
+		rms = append(rms, c.RMS.String())
+		dc = append(dc, fmt.Sprintf("%.6f", c.DCOffset))
+	}
+	duration := time.Duration(info.Duration * float64(time.Second)).Round(time.Millisecond)
+	fmt.Printf("Filename:\t%s\nFormat:\t\t%s\nNumChannels:\t%d\nSamplerate:\t%d\nBit Depth:\t%d\n",
+		info.File, format, info.Channels, info.SampleRate, info.BitDepth)
+	fmt.Printf("Frames:\t\t%d\nDuration:\t%s\nChunks:\t\t%s\n",
+		info.Frames, duration, strings.Join(chunks, ", "))
+	fmt.Printf("Peak:\t\t%s dBFS (%s)\nRMS:\t\t%s dBFS (%s)\nDC offset:\t%s\nLoudness:\t%s LUFS\n",
+		info.Peak, strings.Join(peaks, ", "), info.RMS, strings.Join(rms, ", "), strings.Join(dc, ", "), info.Loudness)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestRow is one line of a job manifest.
+type manifestRow struct {
+	Line   int
+	Input  string
+	Output string
+	// Overrides maps dotted config keys such as "master.gain" or
+	// "parametric.1.freq" to the value to use for this file.
+	Overrides map[string]string
+}
+
+// readManifest reads a CSV (.csv) or JSON lines (anything else) manifest.
+//
+// A CSV manifest has a header row naming its columns: input, output and
+// any number of dotted config keys.  Empty cells leave the key alone.
+//
+// A JSON lines manifest has one object per line:
+//
+//	{"input": "a.wav", "output": "b.wav", "overrides": {"master.gain": 0.8}}
+func readManifest(path string) ([]manifestRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rows []manifestRow
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		rows, err = readCSVManifest(f)
+	} else {
+		rows, err = readJSONManifest(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rows, nil
+}
+
+func readCSVManifest(r io.Reader) ([]manifestRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	input, output := -1, -1
+	for i, h := range header {
+		header[i] = strings.TrimSpace(h)
+		switch strings.ToLower(header[i]) {
+		case "input":
+			input = i
+		case "output":
+			output = i
+		}
+	}
+	if input < 0 {
+		return nil, fmt.Errorf("no input column")
+	}
+
+	var rows []manifestRow
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := manifestRow{Line: line, Input: rec[input], Overrides: map[string]string{}}
+		if output >= 0 {
+			row.Output = rec[output]
+		}
+		for i, v := range rec {
+			if i != input && i != output && v != "" {
+				row.Overrides[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readJSONManifest(r io.Reader) ([]manifestRow, error) {
+	var rows []manifestRow
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var obj struct {
+			Input     string
+			Output    string
+			Overrides map[string]interface{}
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		// keep numbers as written
+		dec.UseNumber()
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		row := manifestRow{Line: line, Input: obj.Input, Output: obj.Output, Overrides: map[string]string{}}
+		for k, v := range obj.Overrides {
+			row.Overrides[k] = fmt.Sprint(v)
+		}
+		rows = append(rows, row)
+	}
+	return rows, sc.Err()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []manifestRow
+	}{
+		{
+			"columns", "input,output,master.gain\na.wav,b.wav,0.8\nc.wav,,\n",
+			[]manifestRow{
+				{Line: 2, Input: "a.wav", Output: "b.wav", Overrides: map[string]string{"master.gain": "0.8"}},
+				{Line: 3, Input: "c.wav", Overrides: map[string]string{}},
+			},
+		},
+		{
+			"header case and spaces", " Master.Gain, INPUT\n0.5, a.wav\n",
+			[]manifestRow{{Line: 2, Input: "a.wav", Overrides: map[string]string{"Master.Gain": "0.5"}}},
+		},
+		{"header only", "input,output\n", nil},
+	}
+	for _, tt := range tests {
+		rows, err := readCSVManifest(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, rows, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"output,master.gain\nb.wav,0.8\n",
+		"input,output\na.wav,b.wav,extra\n",
+		"input,output\n\"a.wav,b.wav\n",
+	} {
+		if _, err := readCSVManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestReadJSONManifest(t *testing.T) {
+	data := `{"input": "a.wav", "output": "b.wav", "overrides": {"master.gain": 0.80, "hpf.type": "butter"}}
+
+{"input": "c.wav"}
+`
+	want := []manifestRow{
+		{Line: 1, Input: "a.wav", Output: "b.wav", Overrides: map[string]string{"master.gain": "0.80", "hpf.type": "butter"}},
+		{Line: 3, Input: "c.wav", Overrides: map[string]string{}},
+	}
+	rows, err := readJSONManifest(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v, want %+v", rows, want)
+	}
+
+	tests := []struct {
+		data string
+		line string
+	}{
+		{`{"input": "a.wav"` + "\n", "line 1"},
+		{`{"input": "a.wav"}` + "\n" + `{"input": "b.wav", "gain": 0.8}`, "line 2"},
+		{`["a.wav", "b.wav"]`, "line 1"},
+	}
+	for _, tt := range tests {
+		_, err := readJSONManifest(strings.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.line) {
+			t.Errorf("%q: got %v, want an error on %s", tt.data, err, tt.line)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"soxy/wavio"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag.Value holding a number of bytes, given as a plain
+// number or with a K, M, G or T suffix such as "512M" or "1.5G".
+type byteSize int64
+
+func (b *byteSize) String() string {
+	switch n := int64(*b); {
+	case n == 0:
+		return "0"
+	case n%(1<<30) == 0:
+		return fmt.Sprintf("%dG", n>>30)
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	s := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(value), "B"))
+	unit := 1.0
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		unit = float64(int64(1) << (10 * uint(strings.IndexByte("KMGT", s[i])+1)))
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad size %q", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
+
+// footprint estimates the memory processing j can take from its WAV
+// header, see pipeline.Config.Footprint.  Files whose header can't be read
+// count as 1 byte; they fail as soon as they start.
+func footprint(j job) int64 {
+	f, err := os.Open(j.InFile)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	w, err := wavio.NewReader(f)
+	if err != nil || w.NumChans == 0 || w.BitDepth < 8 {
+		return 1
+	}
+	frames := w.Frames()
+	if frames < 0 {
+		// a streamed header, go by the size of the file
+		fi, err := f.Stat()
+		if err != nil {
+			return 1
+		}
+		frames = fi.Size() / int64(w.NumChans) / int64(w.BitDepth/8)
+	}
+	n, err := j.C.Footprint(frames, int(w.NumChans), int(w.SampleRate))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// admission keeps the estimated memory of the running jobs within limit.
+// A job larger than the whole limit is admitted once nothing else runs, so
+// it runs alone.  A limit of 0 admits everything.  It isn't safe for
+// concurrent use; the goroutine handing out jobs owns it.
+type admission struct {
+	limit   int64
+	used    int64
+	running int
+}
+
+// fits reports whether a job needing n bytes can start now.
+func (a *admission) fits(n int64) bool {
+	return a.limit <= 0 || a.running == 0 || a.used+n <= a.limit
+}
+
+func (a *admission) admit(n int64) {
+	a.used += n
+	a.running++
+}
+
+func (a *admission) release(n int64) {
+	a.used -= n
+	a.running--
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"soxy/pipeline"
+	"testing"
+	"time"
+)
+
+func TestByteSizeSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  byteSize
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"1000", 1000, true},
+		{"512K", 512 << 10, true},
+		{"512M", 512 << 20, true},
+		{"8G", 8 << 30, true},
+		{"1.5G", 3 << 29, true},
+		{"2T", 2 << 40, true},
+		{"8g", 8 << 30, true},
+		{"8GB", 8 << 30, true},
+		{" 64m ", 64 << 20, true},
+		{"", 0, false},
+		{"G", 0, false},
+		{"8X", 0, false},
+		{"8GG", 0, false},
+		{"-1G", 0, false},
+		{"one", 0, false},
+	}
+	for _, tt := range tests {
+		var b byteSize
+		err := b.Set(tt.value)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		if b != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.value, b, tt.want)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	a := &admission{limit: 100}
+	steps := []struct {
+		op   string // "admit" or "release" n, after checking fits(n) for admit
+		n    int64
+		fits bool
+	}{
+		{"admit", 60, true},
+		{"admit", 30, true},
+		{"admit", 20, false},
+		{"release", 60, false},
+		{"admit", 20, true},
+		// an oversize file waits for the others, then runs alone
+		{"admit", 500, false},
+		{"release", 30, false},
+		{"admit", 500, false},
+		{"release", 20, false},
+		{"admit", 500, true},
+		{"admit", 1, false},
+		{"release", 500, false},
+		{"admit", 1, true},
+	}
+	for i, s := range steps {
+		if s.op == "release" {
+			a.release(s.n)
+			continue
+		}
+		if got := a.fits(s.n); got != s.fits {
+			t.Errorf("step %d: fits(%d) = %v with %d used by %d jobs, want %v", i, s.n, got, a.used, a.running, s.fits)
+		}
+		if s.fits {
+			a.admit(s.n)
+		}
+	}
+	if a.used != 1 || a.running != 1 {
+		t.Errorf("got %d used by %d jobs, want 1 by 1", a.used, a.running)
+	}
+
+	unlimited := &admission{}
+	unlimited.admit(1 << 40)
+	if !unlimited.fits(1 << 40) {
+		t.Error("no limit: expected everything to fit")
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-footprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	short, long := filepath.Join(dir, "short.wav"), filepath.Join(dir, "long.wav")
+	for _, f := range []struct {
+		path string
+		d    time.Duration
+	}{{short, time.Second}, {long, 20 * time.Second}} {
+		if err := gen(f.path, "sine", 1000, 0, -20, f.d, 48000, 16, false, 2, 1, "none"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var c pipeline.Config
+	c.Master.SampleRate = 48000
+	c.Master.InternalRate = "96000"
+	// streamed files take the same whatever their length
+	s, l := footprint(job{InFile: short, C: c}), footprint(job{InFile: long, C: c})
+	if s <= 1 || s != l {
+		t.Errorf("streamed: %d for 1s, %d for 20s, want the same", s, l)
+	}
+	// normalizing holds the whole file as 64 bit floats on top of that
+	c.Master.PeakNorm = "-1"
+	ns, nl := footprint(job{InFile: short, C: c}), footprint(job{InFile: long, C: c})
+	if want := int64(19*48000) * 2 * 8; nl-ns != want {
+		t.Errorf("peaknorm: 20s takes %d more than 1s, want %d", nl-ns, want)
+	}
+	if ns <= s {
+		t.Errorf("peaknorm: %d for 1s, want more than streamed %d", ns, s)
+	}
+	if n := footprint(job{InFile: filepath.Join(dir, "missing.wav"), C: c}); n != 1 {
+		t.Errorf("missing file: %d, want 1", n)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"soxy/pipeline"
+	"soxy/tempr"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxConfigSize limits configs sent to the server.
+const maxConfigSize = 1 << 20
+
+// Batch job states.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobCanceled = "cancelled"
+	jobFailed   = "failed"
+)
+
+// server is the state behind soxy serve.
+type server struct {
+	presets string
+	workers int
+	// slots limits how many files /process renders at once.
+	slots chan struct{}
+	queue chan *batchJob
+
+	mu     sync.Mutex
+	jobs   map[string]*batchJob
+	order  []string
+	nextID int
+}
+
+// batchJob is a folder submitted to /jobs.  The exported fields are its
+// status, they are only read or written with server.mu held.
+type batchJob struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	InPath    string    `json:"inPath"`
+	OutPath   string    `json:"outPath"`
+	Total     int       `json:"total"`
+	Processed int       `json:"processed"`
+	Skipped   int       `json:"skipped"`
+	Failed    int       `json:"failed"`
+	Failures  []failure `json:"failures"`
+	// Error is why a failed job couldn't start.
+	Error string `json:"error,omitempty"`
+
+	todo   []job
+	resume bool
+}
+
+// jobRequest is the body of POST /jobs.  Config is a TOML or JSON config
+// as a string, or a JSON config object.  There is no clean: the server
+// never deletes an output folder.
+type jobRequest struct {
+	InPath    string
+	OutPath   string
+	Manifest  string
+	Preset    string
+	Config    json.RawMessage
+	Recursive bool
+	Include   []string
+	Exclude   []string
+	Resume    bool
+}
+
+func cmdServe(args []string) int {
+	fs := newFlagSet("serve")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	presets := fs.String("presets", "configs", "folder holding the configs that can be named as presets")
+	n := fs.Int("workers", runtime.NumCPU(), "files processed at once, for /process and for batch jobs each")
+	fs.Var(&memory, "memory", "memory budget for the files of a batch job processed at once, such as 8G (default no limit)")
+	fs.Parse(args)
+	if fs.NArg() > 0 || *n < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	s := &server{
+		presets: *presets,
+		workers: *n,
+		slots:   make(chan struct{}, *n),
+		queue:   make(chan *batchJob, 100),
+		jobs:    map[string]*batchJob{},
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	jobsDone := make(chan struct{})
+	go func() {
+		s.runJobs(ctx)
+		close(jobsDone)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process", s.handleProcess)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	mux.HandleFunc("/status", s.handleStatus)
+	// requests share ctx, so a signal cancels the files being rendered
+	srv := &http.Server{
+		Addr:        *addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	shutdown := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+		close(shutdown)
+	}()
+	log.Printf("listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Print(err)
+		return 1
+	}
+	<-shutdown
+	<-jobsDone
+	log.Print("stopped")
+	return 0
+}
+
+// preset loads the config called name, a path below the presets folder
+// without the .toml extension such as "voice/enus_uprez".
+func (s *server) preset(name string) (pipeline.Config, error) {
+	name = strings.TrimSuffix(name, ".toml")
+	if filepath.IsAbs(name) || strings.Contains(name, "..") {
+		return pipeline.Config{}, fmt.Errorf("unknown preset %q", name)
+	}
+	path := filepath.Join(s.presets, filepath.FromSlash(name)+".toml")
+	if _, err := os.Stat(path); err != nil {
+		return pipeline.Config{}, fmt.Errorf("unknown preset %q", name)
+	}
+	return pipeline.LoadConfig(path)
+}
+
+// presetNames lists the presets that can be asked for.
+func (s *server) presetNames() []string {
+	files, _ := discover(s.presets, true, patterns{"*.toml"}, nil, "")
+	names := []string{}
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.ToSlash(f), ".toml"))
+	}
+	return names
+}
+
+// config returns the preset or the decoded config, exactly one of which
+// must be given, after validating it.  Configs can extend the presets but
+// nothing outside the presets folder.
+func (s *server) config(preset string, data []byte) (pipeline.Config, error) {
+	var c pipeline.Config
+	var err error
+	switch {
+	case preset != "" && len(data) != 0:
+		return c, errors.New("give either a preset or a config, not both")
+	case preset != "":
+		c, err = s.preset(preset)
+	case len(data) != 0:
+		c, err = pipeline.ParseConfigWithin(data, s.presets)
+	default:
+		return c, errors.New("no preset or config given")
+	}
+	if err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// handleProcess renders one file.  The WAV is either the request body,
+// with the preset in the query string, or the "audio" part of a multipart
+// form with a "preset" field or a "config" field or file.  The response
+// is the processed WAV; the gains applied are in Soxy-Loudness-Gain and
+// Soxy-Peak-Gain and any warnings in Soxy-Note.
+func (s *server) handleProcess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	audio := io.Reader(r.Body)
+	preset := r.URL.Query().Get("preset")
+	var data []byte
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		f, _, err := r.FormFile("audio")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("no audio part"))
+			return
+		}
+		defer f.Close()
+		audio = f
+		if v := r.FormValue("preset"); v != "" {
+			preset = v
+		}
+		data = []byte(r.FormValue("config"))
+		if cf, _, err := r.FormFile("config"); err == nil {
+			data, err = ioutil.ReadAll(io.LimitReader(cf, maxConfigSize))
+			cf.Close()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+	c, err := s.config(preset, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := pipeline.New(c)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-r.Context().Done():
+		// the client went away or the server is stopping
+		writeError(w, http.StatusServiceUnavailable, r.Context().Err())
+		return
+	}
+	defer func() { <-s.slots }()
+	// render to a file first so failures can still be reported and the
+	// gains can go in the headers
+	tmp, err := tempr.TempFile("", "soxy", ".wav")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	report, err := p.Process(r.Context(), audio, tmp, nil)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if pe, ok := err.(*pipeline.Error); ok && pe.Stage != pipeline.StageWrite {
+			code = http.StatusBadRequest
+		}
+		if r.Context().Err() != nil {
+			// the client went away or the server is stopping
+			code = http.StatusServiceUnavailable
+		}
+		writeError(w, code, err)
+		return
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "audio/wav")
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	h.Set("Soxy-Loudness-Gain", fmt.Sprintf("%.2f", report.LoudnessGain))
+	h.Set("Soxy-Peak-Gain", fmt.Sprintf("%.2f", report.PeakGain))
+	for _, n := range report.Notes {
+		h.Add("Soxy-Note", n)
+	}
+	io.Copy(w, tmp)
+}
+
+// handleJobs lists the batch jobs (GET) or queues a new one (POST).
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		list := []*batchJob{}
+		for _, id := range s.order {
+			list = append(list, s.jobs[id])
+		}
+		writeJSON(w, http.StatusOK, list)
+		s.mu.Unlock()
+	case http.MethodPost:
+		j, err := s.newJob(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// the job is listed before runJobs can see it, so its updates
+		// never race the listing
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jobs[j.ID] = j
+		s.order = append(s.order, j.ID)
+		select {
+		case s.queue <- j:
+		default:
+			delete(s.jobs, j.ID)
+			s.order = s.order[:len(s.order)-1]
+			writeError(w, http.StatusServiceUnavailable, errors.New("too many jobs queued"))
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		writeJSON(w, http.StatusAccepted, j)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET or POST"))
+	}
+}
+
+// newJob checks a job request and lists its files.  Nothing is written,
+// not even the output folder, until the job's turn comes.
+func (s *server) newJob(body io.Reader) (*batchJob, error) {
+	var req jobRequest
+	dec := json.NewDecoder(io.LimitReader(body, maxConfigSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.InPath == "" && req.Manifest == "" || req.OutPath == "" {
+		return nil, errors.New("give an outPath and an inPath or a manifest")
+	}
+	if upward(req.InPath) || upward(req.Manifest) {
+		return nil, errors.New("inPath and manifest can't go up with ..")
+	}
+	data := []byte(req.Config)
+	var text string
+	if json.Unmarshal(req.Config, &text) == nil {
+		// a TOML or JSON config sent as a string
+		data = []byte(text)
+	}
+	c, err := s.config(req.Preset, data)
+	if err != nil {
+		return nil, err
+	}
+	b := batch{
+		InPath:    req.InPath,
+		OutPath:   req.OutPath,
+		Manifest:  req.Manifest,
+		Recursive: req.Recursive,
+		Include:   patterns(req.Include),
+		Exclude:   patterns(req.Exclude),
+	}
+	// checked again when the job starts, this is to fail early
+	if err := checkJobOutPath(req.OutPath, req.Resume); err != nil {
+		return nil, err
+	}
+	todo, err := b.jobs(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.mu.Unlock()
+	return &batchJob{
+		ID:       id,
+		State:    jobQueued,
+		InPath:   req.InPath,
+		OutPath:  req.OutPath,
+		Total:    len(todo),
+		Failures: []failure{},
+		todo:     todo,
+		resume:   req.Resume,
+	}, nil
+}
+
+// runJobs processes the queued batch jobs one after another until ctx is
+// cancelled.  The job running then is cancelled like an interrupted
+// soxy process run, and can be resumed.
+func (s *server) runJobs(ctx context.Context) {
+	for {
+		var j *batchJob
+		select {
+		case <-ctx.Done():
+			return
+		case j = <-s.queue:
+		}
+		state, err := s.startJob(j)
+		if err != nil {
+			log.Printf("job %s: %v", j.ID, err)
+			continue
+		}
+		outcomes := runBatch(ctx, j.todo, state, j.OutPath, s.workers, int64(memory), func(r result) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			switch {
+			case r.Canceled:
+			case r.Err != nil:
+				j.Failed++
+				j.Failures = append(j.Failures, toFailure(r))
+			case r.Skipped:
+				j.Skipped++
+			default:
+				j.Processed++
+				logNotes(r.File, r.Notes)
+			}
+		})
+		// leave failures.json behind like the command line does
+		if _, err := summarize(ioutil.Discard, outcomes, j.OutPath); err != nil {
+			log.Print(err)
+		}
+		s.mu.Lock()
+		j.State = jobDone
+		if ctx.Err() != nil {
+			j.State = jobCanceled
+			log.Printf("job %s cancelled with %d files not processed", j.ID, unfinished(j.todo, outcomes))
+		}
+		j.todo = nil
+		s.mu.Unlock()
+	}
+}
+
+// startJob prepares the output folder of j and loads its state.  The job
+// is marked running, or failed with the error.
+func (s *server) startJob(j *batchJob) (runState, error) {
+	err := checkJobOutPath(j.OutPath, j.resume)
+	if err == nil {
+		err = os.MkdirAll(j.OutPath, 0755)
+	}
+	var state runState
+	if err == nil {
+		state, err = loadState(j.OutPath)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		j.State, j.Error, j.todo = jobFailed, err.Error(), nil
+		return nil, err
+	}
+	j.State = jobRunning
+	return state, nil
+}
+
+// checkJobOutPath refuses an output folder going up with .. or with
+// files in it unless the job resumes.
+func checkJobOutPath(dir string, resume bool) error {
+	if upward(dir) {
+		return fmt.Errorf("outPath %s can't go up with ..", dir)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) > 0 && !resume {
+		return fmt.Errorf("%s is not empty: set resume to continue a run", dir)
+	}
+	return nil
+}
+
+// upward reports whether path has a ".." element.
+func upward(path string) bool {
+	for _, e := range strings.Split(filepath.ToSlash(path), "/") {
+		if e == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// handleJob reports the status of the job in the path.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// handleStatus reports the presets and how busy the server is.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	counts := map[string]int{jobQueued: 0, jobRunning: 0, jobDone: 0, jobFailed: 0}
+	for _, j := range s.jobs {
+		counts[j.State]++
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workers":    s.workers,
+		"processing": len(s.slots),
+		"jobs":       counts,
+		"presets":    s.presetNames(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
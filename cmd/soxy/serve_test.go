@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// servePreset peak normalizes to -1 dBFS at the input rate.
+const servePreset = "[master]\ngain=1.0\nbitdepth=16.0\nsamplerate=48000\ninternalrate=\"native\"\ndither=\"none\"\npeaknorm=\"-1\"\n"
+
+// newTestServer returns a server with one worker and room for one queued
+// job, the preset voice/p in root/presets, a config outside it in
+// root/secret.toml and two WAV files in root/in.
+func newTestServer(t *testing.T, root string) *server {
+	t.Helper()
+	files := map[string]string{
+		"presets/voice/p.toml": servePreset,
+		"secret.toml":          servePreset,
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.wav", "b.wav"} {
+		if err := gen(filepath.Join(root, "in", name), "sine", 1000, 0, -20, 100*time.Millisecond, 48000, 16, false, 1, 1, "none"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &server{
+		presets: filepath.Join(root, "presets"),
+		workers: 1,
+		slots:   make(chan struct{}, 1),
+		queue:   make(chan *batchJob, 1),
+		jobs:    map[string]*batchJob{},
+	}
+}
+
+// multipartBody returns a form with the audio part and the given fields.
+func multipartBody(t *testing.T, audio []byte, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("audio", "take.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(audio)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+func TestServeProcess(t *testing.T) {
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := newTestServer(t, root)
+	audio, err := ioutil.ReadFile(filepath.Join(root, "in", "a.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		fields map[string]string // sent as a multipart form when set
+		code   int
+	}{
+		{"preset", "POST", "?preset=voice/p", nil, http.StatusOK},
+		{"preset with extension", "POST", "?preset=voice/p.toml", nil, http.StatusOK},
+		{"config extending a preset", "POST", "", map[string]string{"config": "extends=\"voice/p.toml\"\n"}, http.StatusOK},
+		{"preset field", "POST", "", map[string]string{"preset": "voice/p"}, http.StatusOK},
+		{"GET", "GET", "?preset=voice/p", nil, http.StatusMethodNotAllowed},
+		{"no config", "POST", "", nil, http.StatusBadRequest},
+		{"unknown preset", "POST", "?preset=voice/nope", nil, http.StatusBadRequest},
+		{"preset going up", "POST", "?preset=../secret", nil, http.StatusBadRequest},
+		{"absolute preset", "POST", "?preset=" + filepath.ToSlash(filepath.Join(root, "secret")), nil, http.StatusBadRequest},
+		{"preset and config", "POST", "?preset=voice/p", map[string]string{"config": servePreset}, http.StatusBadRequest},
+		{"extends going up", "POST", "", map[string]string{"config": "extends=\"../secret.toml\"\n"}, http.StatusBadRequest},
+		{"absolute extends", "POST", "", map[string]string{"config": "extends=\"" + filepath.ToSlash(filepath.Join(root, "secret.toml")) + "\"\n"}, http.StatusBadRequest},
+		{"bad config", "POST", "", map[string]string{"config": "[master]\nnope=1\n"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, ctype := bytes.NewBuffer(audio), "audio/wav"
+		if tt.fields != nil {
+			body, ctype = multipartBody(t, audio, tt.fields)
+		}
+		req := httptest.NewRequest(tt.method, "/process"+tt.query, body)
+		req.Header.Set("Content-Type", ctype)
+		rec := httptest.NewRecorder()
+		s.handleProcess(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			var e map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil || e["error"] == "" {
+				t.Errorf("%s: body %q is not a JSON error", tt.name, rec.Body)
+			}
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "audio/wav" || !bytes.HasPrefix(rec.Body.Bytes(), []byte("RIFF")) {
+			t.Errorf("%s: got %s %q", tt.name, ct, rec.Body.Bytes()[:4])
+		}
+		if g := rec.Header().Get("Soxy-Peak-Gain"); g != "19.00" {
+			t.Errorf("%s: Soxy-Peak-Gain %s, want 19.00", tt.name, g)
+		}
+	}
+
+	// a request that gives up while waiting for a slot
+	s.slots <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/process?preset=voice/p", bytes.NewReader(audio)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	s.handleProcess(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("cancelled while waiting: status %d, want 503", rec.Code)
+	}
+	<-s.slots
+}
+
+// postJob posts body to s and returns the status and the decoded answer.
+func postJob(s *server, body string) (int, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	s.handleJobs(rec, httptest.NewRequest("POST", "/jobs", strings.NewReader(body)))
+	var v map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &v)
+	return rec.Code, v
+}
+
+func TestServeJobRejected(t *testing.T) {
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := newTestServer(t, root)
+	in, out := filepath.Join(root, "in"), filepath.Join(root, "out")
+	if err := os.MkdirAll(filepath.Join(root, "full"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "full", "x.wav"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := func(fields string) string {
+		return `{"inPath": "` + filepath.ToSlash(in) + `", ` + fields + `}`
+	}
+	outField := `"outPath": "` + filepath.ToSlash(out) + `"`
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", "inPath=in"},
+		{"no outPath", job(`"preset": "voice/p"`)},
+		{"unknown field", job(outField + `, "preset": "voice/p", "clean": true`)},
+		{"bad preset name", job(outField + `, "preset": "../secret"`)},
+		{"unknown preset", job(outField + `, "preset": "voice/nope"`)},
+		{"outPath going up", job(`"outPath": "` + filepath.ToSlash(in) + `/../out", "preset": "voice/p"`)},
+		{"inPath going up", `{"inPath": "` + filepath.ToSlash(out) + `/../in", ` + outField + `, "preset": "voice/p"}`},
+		{"manifest going up", `{"manifest": "../list.csv", ` + outField + `, "preset": "voice/p"}`},
+		{"absolute extends", job(outField + `, "config": "extends=\"` + filepath.ToSlash(filepath.Join(root, "secret.toml")) + `\"\n"`)},
+		{"extends going up", job(outField + `, "config": "extends=\"../secret.toml\"\n"`)},
+		{"outPath not empty", job(`"outPath": "` + filepath.ToSlash(filepath.Join(root, "full")) + `", "preset": "voice/p"`)},
+		{"outPath is inPath", job(`"outPath": "` + filepath.ToSlash(in) + `", "preset": "voice/p", "resume": true`)},
+	}
+	for _, tt := range tests {
+		code, v := postJob(s, tt.body)
+		if code != http.StatusBadRequest || v["error"] == nil {
+			t.Errorf("%s: status %d %v, want 400", tt.name, code, v)
+		}
+	}
+
+	// one job fills the queue, nothing runs it
+	if code, v := postJob(s, job(outField+`, "preset": "voice/p"`)); code != http.StatusAccepted || v["state"] != jobQueued {
+		t.Fatalf("first job: status %d %v", code, v)
+	}
+	if code, _ := postJob(s, job(outField+`, "preset": "voice/p"`)); code != http.StatusServiceUnavailable {
+		t.Errorf("queue full: status %d, want 503", code)
+	}
+	if len(s.jobs) != 1 || len(s.order) != 1 {
+		t.Errorf("%d jobs listed after a full queue, want 1", len(s.jobs))
+	}
+	// the output folder only appears once the job starts
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("outPath of a queued job: %v", err)
+	}
+}
+
+func TestServeJob(t *testing.T) {
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := newTestServer(t, root)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.runJobs(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	out := filepath.Join(root, "out", "voice")
+	code, v := postJob(s, `{"inPath": "`+filepath.ToSlash(filepath.Join(root, "in"))+`", "outPath": "`+filepath.ToSlash(out)+`", "config": "extends=\"voice/p.toml\"\n"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("status %d %v", code, v)
+	}
+	id, _ := v["id"].(string)
+
+	var j batchJob
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		rec := httptest.NewRecorder()
+		s.handleJob(rec, httptest.NewRequest("GET", "/jobs/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /jobs/%s: status %d", id, rec.Code)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &j); err != nil {
+			t.Fatal(err)
+		}
+		if j.State != jobQueued && j.State != jobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", j.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if j.State != jobDone || j.Total != 2 || j.Processed != 2 || j.Failed != 0 {
+		t.Errorf("job ended %+v", j)
+	}
+	for _, name := range []string{"a.wav", "b.wav", stateFile} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleJob(rec, httptest.NewRequest("GET", "/jobs/99", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /jobs/99: status %d, want 404", rec.Code)
+	}
+}
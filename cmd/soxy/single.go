@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"soxy/pipeline"
+)
+
+// stdio is the file name that stands for stdin with -in and stdout with
+// -out.
+const stdio = "-"
+
+// partialSuffix is added to the name of an output while it is written.  It
+// is renamed once complete, so an output that exists is never half written.
+const partialSuffix = ".partial"
+
+// runSingle renders the one file given with -in to -out and returns the
+// exit status.  Either can be "-" so soxy can sit in a pipeline; only the
+// WAV goes to stdout, messages go to stderr.
+func runSingle() int {
+	if inPath != "" || manifest != "" {
+		log.Fatal("use either -in or -inPath/-manifest, not both")
+	}
+	if outWav == "" {
+		log.Fatal("-in needs -out (- for stdout)")
+	}
+	if progressPath != "" {
+		log.Fatal("-progress reports on batches, it can't be used with -in")
+	}
+	if spectro && outWav == stdio {
+		log.Fatal("-spectro needs -out to be a file")
+	}
+	c, err := pipeline.LoadConfig(inConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := pipeline.New(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	name := inWav
+	var in io.Reader = os.Stdin
+	if inWav == stdio {
+		name = "stdin"
+	} else {
+		f, err := os.Open(inWav)
+		if err != nil {
+			log.Print(stageError(name, stageOpen, err))
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	report, err := renderTo(ctx, p, name, in, outWav, nil)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	logNotes(name, notes(report))
+	if spectro {
+		// the stats go next to the output
+		outPath = filepath.Dir(outWav)
+		if err := writeStats(outWav, filepath.Base(outWav)); err != nil {
+			log.Print(stageError(name, stageStats, err))
+			return 1
+		}
+	}
+	return 0
+}
+
+// renderTo renders in to the file outFile, or to stdout when outFile is
+// "-".  stages is passed on to pipeline.Process.  The file is written
+// under a partial name and only takes its own once complete; when rendering fails
+// or ctx is cancelled the partial file is removed and an earlier outFile
+// is left alone.
+func renderTo(ctx context.Context, p *pipeline.Pipeline, name string, in io.Reader, outFile string, stages pipeline.StageFunc) (pipeline.Report, error) {
+	if outFile == stdio {
+		return run(ctx, p, name, in, os.Stdout, stages)
+	}
+	partial := outFile + partialSuffix
+	out, err := os.Create(partial)
+	if err != nil {
+		return pipeline.Report{}, stageError(name, pipeline.StageWrite, err)
+	}
+	defer os.Remove(partial)
+	defer out.Close()
+	report, err := run(ctx, p, name, in, out, stages)
+	if err != nil {
+		return report, err
+	}
+	if err := out.Close(); err != nil {
+		return report, stageError(name, pipeline.StageWrite, err)
+	}
+	if err := os.Rename(partial, outFile); err != nil {
+		return report, stageError(name, pipeline.StageWrite, err)
+	}
+	return report, nil
+}
+
+// run processes in to out with the pipeline.  Its notes are left to the
+// caller, which may be drawing a progress bar.
+func run(ctx context.Context, p *pipeline.Pipeline, name string, in io.Reader, out io.Writer, stages pipeline.StageFunc) (pipeline.Report, error) {
+	report, err := p.Process(ctx, in, out, stages)
+	if err != nil {
+		return report, stageError(name, pipeline.StageWrite, err)
+	}
+	return report, nil
+}
+
+// notes returns the warnings of report and the peak normalization gain,
+// as lines for the log.
+func notes(report pipeline.Report) []string {
+	n := append([]string(nil), report.Notes...)
+	if report.PeakGain != 0 {
+		n = append(n, fmt.Sprintf("peak normalized by %+.2f dB", report.PeakGain))
+	}
+	return n
+}
+
+// logNotes logs the notes of the file name.
+func logNotes(name string, notes []string) {
+	for _, n := range notes {
+		log.Printf("%s: %s", name, n)
+	}
+}
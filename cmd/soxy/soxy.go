@@ -2,319 +2,508 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"soxy/biquad/hpf"
-	"soxy/biquad/lpf"
-	"soxy/biquad/parametric"
-	"soxy/compressor"
-	"soxy/resample/smarc"
-	"soxy/tempr"
-	"strconv"
+	"soxy/pipeline"
+	"soxy/wavio"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/go-audio/audio"
-	"github.com/go-audio/transforms"
-	"github.com/go-audio/wav"
-	"github.com/naoina/toml"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
-var bitDepthConvert = map[float64]string{
-	24.0: "pcm_s24le",
-	16.0: "pcm_s16le",
-}
-
+// Settings of the process command.  The same flags are accepted without a
+// command for compatibility.
 var (
-	info     = flag.String("i", "", "get info about the file")
-	inPath   = flag.String("inPath", "", "input path with many waves")
-	outPath  = flag.String("outPath", "", "output folder")
-	inConfig = flag.String("c", "", "path to config")
-	spectro  = flag.Bool("spectro", false, "also create spectrograms")
-	workers  = flag.Int("workers", runtime.NumCPU(), "Number of go routines to use.")
+	inPath    string
+	outPath   string
+	inConfig  string
+	spectro   bool
+	workers   int
+	resume    bool
+	clean     bool
+	manifest  string
+	recursive bool
+	include   patterns
+	exclude   patterns
+	memory    byteSize
+	// progressPath is where -progress events go.
+	progressPath string
+	// inWav and outWav name a single file to process instead of a batch.
+	inWav  string
+	outWav string
 )
 
-type config struct {
-	Master struct {
-		Gain              float64
-		BitDepth          float64
-		SampleRate        int
-		Bandwidth         float64
-		RippleFactor      float64
-		RippleAttenuation float64
-		Tolerance         float64
-		Normalize         bool
-
-		IntegratedLoudness string
-		LoudnessRange      string
-		TruePeak           string
-		PeakNorm           string
-
-		SoxNorm   bool
-		SoxNormTo string
-	}
-	Compressor *compressor.Compressor
-	Parametric []*parametric.Parametric
-	HPF        *hpf.HPF
-	LPF        *lpf.LPF
+// addProcessFlags registers the settings of the process command on fs.
+func addProcessFlags(fs *flag.FlagSet) {
+	fs.StringVar(&inPath, "inPath", "", "input path with many waves")
+	fs.StringVar(&outPath, "outPath", "", "output folder")
+	fs.StringVar(&inWav, "in", "", "process this one file instead of inPath, - reads stdin")
+	fs.StringVar(&outWav, "out", "", "output file for -in, - writes stdout")
+	fs.StringVar(&inConfig, "c", "", "path to config")
+	fs.BoolVar(&spectro, "spectro", false, "also create spectrograms")
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "Number of go routines to use.")
+	fs.BoolVar(&resume, "resume", false, "skip files whose input and config are unchanged since they were last written to outPath")
+	fs.BoolVar(&clean, "clean", false, "delete outPath first if it is not empty")
+	fs.StringVar(&manifest, "manifest", "", "CSV or JSON lines file listing the files to process, used instead of scanning inPath")
+	fs.BoolVar(&recursive, "recursive", false, "also process the sub folders of inPath and mirror them in outPath")
+	fs.Var(&include, "include", "comma separated glob patterns of files to process (default *.wav)")
+	fs.Var(&exclude, "exclude", "comma separated glob patterns of files and folders to skip")
+	fs.StringVar(&progressPath, "progress", "", "write JSON lines progress events to this file, - for stdout")
+	fs.Var(&memory, "memory", "memory budget for the files processed at once, such as 8G; a file estimated to need more is processed alone (default no limit)")
 }
 
-// toFloatBuffer converts the buffer to the usable format for
-// processing.  We then use toIntBuffer when we want to write the
-// file back to disk.
-func toFloatBuffer(buf *audio.IntBuffer, bitDepth float64) *audio.FloatBuffer {
-	newB := &audio.FloatBuffer{}
-	newB.Data = make([]float64, len(buf.Data))
-	for i := 0; i < len(buf.Data); i++ {
-		newB.Data[i] = float64(buf.Data[i]) / math.Pow(2, bitDepth)
-	}
-	newB.Format = &audio.Format{
-		NumChannels: buf.Format.NumChannels,
-		SampleRate:  buf.Format.SampleRate,
-	}
-	return newB
-}
-
-func toIntBuffer(buf *audio.FloatBuffer, bitDepth float64) *audio.IntBuffer {
-	newB := &audio.IntBuffer{}
-	newB.Data = make([]int, len(buf.Data))
-	for i := 0; i < len(buf.Data); i++ {
-		newB.Data[i] = int(buf.Data[i] * math.Pow(2, bitDepth))
+// process renders inFile to outFile.  rel is the file's path below the
+// input folder, used to lay out the stats.  Errors are *pipeline.Error
+// values naming the file and the stage that failed.  Cancelling ctx stops
+// the render and leaves no output behind.  The report includes the time
+// spent on the stats, and stages, when not nil, is told about them like
+// about the stages of the pipeline.  When seen isn't nil the whole input
+// is copied to it as it is read.
+func process(ctx context.Context, c pipeline.Config, inFile, outFile, rel string, seen io.Writer, stages pipeline.StageFunc) (pipeline.Report, error) {
+	p, err := pipeline.New(c)
+	if err != nil {
+		return pipeline.Report{}, stageError(inFile, pipeline.StageConfig, err)
 	}
-	newB.Format = &audio.Format{
-		NumChannels: buf.Format.NumChannels,
-		SampleRate:  buf.Format.SampleRate,
+	report, err := convert(ctx, p, inFile, outFile, seen, stages)
+	if err != nil {
+		return report, err
 	}
-	return newB
-}
-
-// printInfo basic replacement for soxi - lets you peek metdata
-func printInfo(name string, w *wav.Decoder) {
-	fmt.Printf("Filename:\t%s\n%s:\t%d\n%s:\t%d\n%s\t%d\n", name, "NumChannels", w.Format().NumChannels, "Samplerate", w.Format().SampleRate, "Bit Depth", w.BitDepth)
+	if spectro {
+		// dump metrics and stats in output folder
+		if stages != nil {
+			stages(stageStats, false, 0)
+		}
+		start := time.Now()
+		if err := writeStats(outFile, rel); err != nil {
+			return report, stageError(inFile, stageStats, err)
+		}
+		took := time.Since(start)
+		report.Stages = append(report.Stages, pipeline.StageTime{Stage: stageStats, Duration: took})
+		if stages != nil {
+			stages(stageStats, true, took)
+		}
+	}
+	return report, nil
 }
 
-func readConfig(inConfig string, val interface{}) error {
-	fc, err := os.Open(inConfig)
+func convert(ctx context.Context, p *pipeline.Pipeline, inFile, outFile string, seen io.Writer, stages pipeline.StageFunc) (pipeline.Report, error) {
+	f, err := os.Open(inFile)
 	if err != nil {
-		return err
+		return pipeline.Report{}, stageError(inFile, stageOpen, err)
 	}
-	defer fc.Close()
-	if err := toml.NewDecoder(fc).Decode(val); err != nil {
-		return err
+	defer f.Close()
+	if seen == nil {
+		return renderTo(ctx, p, inFile, f, outFile, stages)
 	}
-	return nil
-}
-func process(c config, inFile, outFile string) error {
-	// fix the header preemptively
-	// this is required because most of the corpus does not include a pcm chunk
-	tmpFile, err := ioutil.TempFile("", "soxy")
+	report, err := renderTo(ctx, p, inFile, io.TeeReader(f, seen), outFile, stages)
 	if err != nil {
-		log.Fatal(err)
+		return report, err
 	}
-	defer os.Remove(tmpFile.Name())
-	cmd := exec.Command("sox", inFile, "-t", "wavpcm", tmpFile.Name())
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+	// the chunks after the audio
+	if _, err := io.Copy(seen, f); err != nil {
+		return report, stageError(inFile, stageOpen, err)
 	}
+	return report, nil
+}
+
+// writeStats saves a spectrogram, a waveform, the config and sox stats for
+// outFile in the output folder, in sub folders mirroring rel.
+func writeStats(outFile, rel string) error {
+	base := strings.TrimSuffix(rel, filepath.Ext(rel))
+	mkdir := func(kind string) string {
+		dir := filepath.Join(outPath, kind, filepath.Dir(rel))
+		os.MkdirAll(dir, 0755)
+		return dir
+	}
+
+	// draw spectrogram
+	pngFile := filepath.Join(mkdir("Spectrograms"), filepath.Base(base)+".png")
+	cmd := exec.Command("sox", outFile, "-n", "spectrogram", "-o", pngFile)
+	cmd.Run()
 
-	f, err := os.Open(tmpFile.Name())
+	// draw the waveform
+	f, err := os.Open(outFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	w := wav.NewDecoder(f)
-	w.ReadInfo()
-	buf, err := w.FullPCMBuffer()
+	w, err := wavio.NewReader(f)
+	f.Close()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	out, err := os.Create(outFile)
+	dur, err := w.Duration()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer out.Close()
-	// convert to float buffer with range -1 to 1
-	buff := toFloatBuffer(buf, float64(w.BitDepth))
-	transforms.Gain(buff, c.Master.Gain)
-
-	// Resample to 192000 for internal processing.
-	buff.Data = smarc.Resample(buff.Data, int(w.SampleRate), 192000, c.Master.Bandwidth, c.Master.RippleFactor, c.Master.RippleAttenuation, c.Master.Tolerance)
+	wfFile := filepath.Join(mkdir("Waveforms"), filepath.Base(base)+".png")
+	dura := dur.String()
+	cmd = exec.Command("audiowaveform", "-i", outFile, "-o", wfFile, "-b", "16", "-e", dura[:len(dura)-1])
+	cmd.Run()
 
-	if c.HPF != nil {
-		hpf.HighPass(buff, c.HPF.Freq, 192000.0, int(w.NumChans))
-	}
-	if c.LPF != nil {
-		lpf.LowPass(buff, c.LPF.Freq, 192000.0, int(w.NumChans))
-	}
-	if len(c.Parametric) != 0 {
-		for _, eq := range c.Parametric {
-			parametric.EQ(buff, eq.Freq, eq.Gain, eq.Q, 192000.0, int(w.NumChans))
-		}
+	// save the config
+	ff, err := ioutil.ReadFile(inConfig)
+	if err != nil {
+		return err
 	}
-	if c.Compressor != nil {
-		compressor.Compress(buff, c.Compressor.Ratio, c.Compressor.AttackTime, c.Compressor.ReleaseTime, c.Compressor.Threshold, c.Compressor.InputGain, c.Compressor.OutputGain, 192000, c.Compressor.LookAheadDelay, c.Compressor.Knee)
+	configFol := filepath.Join(outPath, "Config")
+	os.MkdirAll(configFol, 0755)
+	_, ctail := filepath.Split(inConfig)
+	if err := ioutil.WriteFile(filepath.Join(configFol, ctail), ff, 0644); err != nil {
+		return err
 	}
 
-	if *spectro {
-		// dump metrics and stats in output folder
-		defer func() {
-			// draw spectrogram
-			specFol := filepath.Join(*outPath, "Spectrograms")
-			os.MkdirAll(specFol, 0755)
-			_, tail := filepath.Split(out.Name())
-			pngFile := filepath.Join(specFol, tail[:len(tail)-4]+".png")
-			cmd := exec.Command("sox", out.Name(), "-n", "spectrogram", "-o", pngFile)
-			cmd.Run()
-
-			// draw the waveform
-			dur, err := w.Duration()
-			if err != nil {
-				log.Fatal(err)
-			}
-			waveFol := filepath.Join(*outPath, "Waveforms")
-			os.MkdirAll(waveFol, 0755)
-			wfFile := filepath.Join(waveFol, tail[:len(tail)-4]+".png")
-			dura := dur.String()
-			cmd = exec.Command("audiowaveform", "-i", out.Name(), "-o", wfFile, "-b", "16", "-e", dura[:len(dura)-1])
-			cmd.Run()
+	var cmdBuf bytes.Buffer
+	cmd = exec.Command("sox", outFile, "-n", "stats")
+	cmd.Stdout = &cmdBuf
+	cmd.Stderr = &cmdBuf
+	cmd.Run()
+	return ioutil.WriteFile(filepath.Join(mkdir("Stats"), filepath.Base(base)+".txt"), cmdBuf.Bytes(), 0644)
+}
 
-			// save the config
-			ff, err := ioutil.ReadFile(*inConfig)
-			if err != nil {
-				log.Fatal(err)
-			}
-			configFol := filepath.Join(*outPath, "Config")
-			os.MkdirAll(configFol, 0755)
-			_, ctail := filepath.Split(*inConfig)
-			if err := ioutil.WriteFile(filepath.Join(configFol, ctail), ff, 0644); err != nil {
-				log.Fatal(err)
-			}
-			statsFol := filepath.Join(*outPath, "Stats")
-			os.MkdirAll(statsFol, 0755)
+type job struct {
+	InFile  string
+	OutFile string
+	// Rel is the path of the file below the input folder.
+	Rel        string
+	C          pipeline.Config
+	ConfigHash string
+	// Memory is the estimated footprint the job was admitted with, 0
+	// when there is no memory budget.
+	Memory int64
+	// Key is the output's entry in the run state, set by runBatch.
+	Key string
+}
 
-			var cmdBuf bytes.Buffer
-			cmd = exec.Command("sox", out.Name(), "-n", "stats")
-			cmd.Stdout = &cmdBuf
-			cmd.Stderr = &cmdBuf
-			cmd.Run()
-			if err := ioutil.WriteFile(filepath.Join(statsFol, tail[:len(tail)-4]+".txt"), cmdBuf.Bytes(), 0644); err != nil {
-				panic(err)
-			}
-		}()
+// worker consumes the jobs channel and sends one result per job.  Jobs
+// whose output in done was made from the same input and config are
+// skipped.  A job that fails because ctx was cancelled is reported as
+// cancelled rather than failed.
+func worker(ctx context.Context, jobs <-chan job, results chan<- result, done runState) {
+	for j := range jobs {
+		start := time.Now()
+		progress.emit(event{Event: evFileStarted, File: j.InFile})
+		r, report := runJob(ctx, j, done)
+		progress.fileDone(j, r, report, time.Since(start))
+		results <- r
 	}
+}
 
-	// write the file down as 192
-	wr := wav.NewEncoder(tmpFile, 192000.0, int(w.BitDepth), int(w.NumChans), int(w.WavAudioFormat))
-	if err := wr.Write(toIntBuffer(buff, float64(w.BitDepth))); err != nil {
-		panic(err)
-	}
-	if err = wr.Close(); err != nil {
-		panic(err)
-	}
-	newRate := strconv.Itoa(c.Master.SampleRate)
-	if c.Master.Normalize {
-		// Loudness normalization first
-		normTemp, err := tempr.TempFile("", "soxy", ".wav")
+// runJob processes j unless done shows its output is up to date.
+// The input is only hashed up front when done has an entry for it;
+// otherwise it is hashed as it is processed, so it is read once.
+func runJob(ctx context.Context, j job, done runState) (result, pipeline.Report) {
+	r := result{File: j.InFile, Rel: j.Rel, Key: j.Key, Memory: j.Memory}
+	if prev, ok := done[r.Key]; ok {
+		hash, err := hashFile(j.InFile)
 		if err != nil {
-			panic(err)
+			r.Err = stageError(j.InFile, stageOpen, err)
+			return r, pipeline.Report{}
 		}
-		loudNormSettings := fmt.Sprintf("loudnorm=I=%s:LRA=%s:TP=%s", c.Master.IntegratedLoudness, c.Master.LoudnessRange, c.Master.TruePeak)
-		command := []string{
-			"-y",
-			"-i",
-			tmpFile.Name(),
-			"-af",
-			loudNormSettings,
-			"-acodec",
-			bitDepthConvert[c.Master.BitDepth],
-			"-ar",
-			newRate,
-			normTemp.Name(),
-		}
-		cmd := exec.Command("ffmpeg", command...)
-		if err := cmd.Run(); err != nil {
-			panic(err)
+		r.State = fileState{InputHash: hash, ConfigHash: j.ConfigHash}
+		if prev == r.State {
+			if _, err := os.Stat(j.OutFile); err == nil {
+				r.Skipped = true
+				return r, pipeline.Report{}
+			}
 		}
-		// Peak normalization
-		cmd = exec.Command("sox", normTemp.Name(), out.Name(), "--norm="+c.Master.PeakNorm)
-		cmd.Run()
-		return nil
+		report, err := process(ctx, j.C, j.InFile, j.OutFile, j.Rel, nil, progress.stages(j.InFile))
+		r.Err = err
+		r.Canceled = err != nil && ctx.Err() != nil
+		r.Notes = notes(report)
+		return r, report
 	}
-	// just do a conversion
-	cmd = exec.Command("ffmpeg", "-y", "-i", tmpFile.Name(), "-acodec", bitDepthConvert[c.Master.BitDepth], "-ar", newRate, out.Name())
-	cmd.Run()
-	return nil
+	h := sha256.New()
+	report, err := process(ctx, j.C, j.InFile, j.OutFile, j.Rel, h, progress.stages(j.InFile))
+	r.Err = err
+	r.Canceled = err != nil && ctx.Err() != nil
+	r.State = fileState{InputHash: hex.EncodeToString(h.Sum(nil)), ConfigHash: j.ConfigHash}
+	r.Notes = notes(report)
+	return r, report
 }
 
-type job struct {
-	InFile  string
-	OutFile string
-	C       config
+// batch names the files of a run and where their output goes.
+type batch struct {
+	InPath    string
+	OutPath   string
+	Manifest  string
+	Recursive bool
+	Include   patterns
+	Exclude   patterns
 }
 
-// worker consumes the jobs channel
-func worker(jobs <-chan job, results chan<- string) {
-	for j := range jobs {
-		if err := process(j.C, j.InFile, j.OutFile); err != nil {
-			results <- fmt.Sprintf("!!! %s failed\n", j.InFile)
+// jobs returns a job for every row of the manifest or, without one, for
+// every file found in InPath.  InPath and OutPath must differ, or the
+// outputs would overwrite their inputs.
+func (b batch) jobs(c pipeline.Config) ([]job, error) {
+	if b.InPath != "" && samePath(b.InPath, b.OutPath) {
+		return nil, fmt.Errorf("outPath %s is the input folder", b.OutPath)
+	}
+	if b.Manifest == "" {
+		if len(b.Include) == 0 {
+			b.Include = patterns{"*.wav"}
+		}
+		files, err := discover(b.InPath, b.Recursive, b.Include, b.Exclude, b.OutPath)
+		if err != nil {
+			return nil, err
 		}
-		results <- fmt.Sprintf("%s done ...\n", j.InFile)
+		hash, err := hashConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		var jobs []job
+		for _, rel := range files {
+			// mirror the input tree under OutPath
+			jobs = append(jobs, job{InFile: filepath.Join(b.InPath, rel), OutFile: filepath.Join(b.OutPath, rel), Rel: rel, C: c, ConfigHash: hash})
+		}
+		return jobs, nil
 	}
-}
-func main() {
-	flag.Parse()
 
-	if *info != "" {
-		f, err := os.Open(*info)
+	rows, err := readManifest(b.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []job
+	// the line that writes each output
+	outputs := map[string]int{}
+	for _, row := range rows {
+		fail := func(err error) error {
+			return fmt.Errorf("%s line %d: %v", b.Manifest, row.Line, err)
+		}
+		if row.Input == "" {
+			return nil, fail(errors.New("no input"))
+		}
+		jc, err := c.WithOverrides(row.Overrides)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fail(err)
+		}
+		if err := jc.Validate(); err != nil {
+			return nil, fail(err)
+		}
+		hash, err := hashConfig(jc)
+		if err != nil {
+			return nil, fail(err)
+		}
+		// relative paths are below InPath and OutPath
+		in := row.Input
+		if !filepath.IsAbs(in) {
+			in = filepath.Join(b.InPath, in)
+		}
+		out := row.Output
+		if out == "" {
+			out = filepath.Base(in)
+		}
+		if !filepath.IsAbs(out) {
+			out = filepath.Join(b.OutPath, out)
+		}
+		if line, ok := outputs[stateKey(b.OutPath, out)]; ok {
+			return nil, fail(fmt.Errorf("%s is also the output of line %d", out, line))
+		}
+		outputs[stateKey(b.OutPath, out)] = row.Line
+		rel, err := filepath.Rel(b.OutPath, out)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(out)
 		}
-		w := wav.NewDecoder(f)
-		w.ReadInfo()
-		_, name := filepath.Split(*info)
-		printInfo(name, w)
-		os.Exit(0)
+		jobs = append(jobs, job{InFile: in, OutFile: out, Rel: rel, C: jc, ConfigHash: hash})
 	}
+	return jobs, nil
+}
 
-	var c config
-	if err := readConfig(*inConfig, &c); err != nil {
-		panic(err)
+// runProcess renders every file of the batch and returns the exit status.
+func runProcess() int {
+	if inWav != "" {
+		return runSingle()
 	}
-	files, err := filepath.Glob(filepath.Join(*inPath, "*.wav"))
+	c, err := pipeline.LoadConfig(inConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// make output path - delete if exists
-	// if err := os.MkdirAll(*outPath, 0755); err != nil {
-	// 	os.RemoveAll(*outPath)
-	// 	os.MkdirAll(*outPath, 0755)
-	// }
-	os.RemoveAll(*outPath)
-	os.MkdirAll(*outPath, 0755)
-	jobs := make(chan job, len(files))
-	results := make(chan string, len(files))
-
-	// start the pool
-	for idx := 0; idx < *workers; idx++ {
-		go worker(jobs, results)
+	if err := c.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	b := batch{InPath: inPath, OutPath: outPath, Manifest: manifest, Recursive: recursive, Include: include, Exclude: exclude}
+	todo, err := b.jobs(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resume && clean {
+		log.Fatal("use either -resume or -clean, not both")
+	}
+	inputs := []string{inPath, manifest}
+	for _, j := range todo {
+		inputs = append(inputs, j.InFile)
 	}
-	for _, fi := range files {
-		_, tail := filepath.Split(fi)
-		pOut := filepath.Join(*outPath, tail)
-		jobs <- job{InFile: fi, OutFile: pOut, C: c}
+	if err := prepareOutPath(outPath, resume, clean, inputs); err != nil {
+		log.Fatal(err)
+	}
+	state, err := loadState(outPath)
+	if err != nil {
+		log.Fatal(err)
 	}
-	close(jobs)
 
-	bar := pb.StartNew(len(files))
-	for range files {
-		bar.Increment()
-		<-results
+	if progressPath != "" {
+		if progress, err = openEvents(progressPath); err != nil {
+			log.Fatal(err)
+		}
+		defer progress.Close()
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	start := time.Now()
+	progress.emit(event{Event: evBatchStarted, Counts: &counts{Total: len(todo)}})
+	bar := pb.New(len(todo))
+	if progressPath == stdio {
+		// keep stdout for the events
+		bar.Output = os.Stderr
 	}
+	bar.Start()
+	outcomes := runBatch(ctx, todo, state, outPath, workers, int64(memory), func(result) {
+		bar.Increment()
+	})
 	bar.Finish()
+	progress.batchFinished(len(todo), outcomes, time.Since(start))
+	// logged now the bar is done with stderr
+	for _, r := range outcomes {
+		logNotes(r.File, r.Notes)
+	}
+
+	failed, err := summarize(os.Stderr, outcomes, outPath)
+	if err != nil {
+		log.Print(err)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "interrupted: %d files not processed, run again with -resume to finish them\n", unfinished(todo, outcomes))
+		return 1
+	}
+	if failed > 0 || err != nil {
+		return 1
+	}
+	return 0
+}
+
+// unfinished counts the jobs of todo that weren't started or were
+// cancelled.
+func unfinished(todo []job, outcomes []result) int {
+	n := len(todo) - len(outcomes)
+	for _, r := range outcomes {
+		if r.Canceled {
+			n++
+		}
+	}
+	return n
+}
+
+// runBatch processes todo with a pool of workers and calls done as each
+// job finishes.  Every finished file is recorded in state, saved in dir,
+// straight away so an interrupted run can pick up from there.  Once ctx is
+// cancelled no more jobs are started and the ones running are cancelled;
+// the jobs never started have no result.  With a memory limit jobs are
+// only started while their estimated footprints fit in it together.
+func runBatch(ctx context.Context, todo []job, state runState, dir string, workers int, limit int64, done func(result)) []result {
+	jobs := make(chan job)
+	results := make(chan result)
+	released := make(chan int64, len(todo))
+	var wg sync.WaitGroup
+
+	// start the pool; the workers get their own copy of the state as it
+	// is updated while they run
+	prev := runState{}
+	for rel, st := range state {
+		prev[rel] = st
+	}
+	for idx := 0; idx < workers; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx, jobs, results, prev)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		adm := &admission{limit: limit}
+		for _, j := range todo {
+			if ctx.Err() != nil {
+				return
+			}
+			j.Key = stateKey(dir, j.OutFile)
+			if err := os.MkdirAll(filepath.Dir(j.OutFile), 0755); err != nil {
+				progress.emit(event{Event: evFileStarted, File: j.InFile})
+				r := result{File: j.InFile, Rel: j.Rel, Key: j.Key, Err: stageError(j.InFile, pipeline.StageWrite, err)}
+				progress.fileDone(j, r, pipeline.Report{}, 0)
+				results <- r
+				continue
+			}
+			if limit > 0 {
+				j.Memory = footprint(j)
+				for !adm.fits(j.Memory) {
+					select {
+					case n := <-released:
+						adm.release(n)
+					case <-ctx.Done():
+						return
+					}
+				}
+				adm.admit(j.Memory)
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	journal, err := openJournal(dir)
+	if err != nil {
+		log.Print(err)
+	}
+	var outcomes []result
+	for r := range results {
+		outcomes = append(outcomes, r)
+		if r.Memory > 0 {
+			released <- r.Memory
+		}
+		switch {
+		case r.Canceled:
+			// the output, if any, is still the one state describes
+		case r.Err == nil && !r.Skipped:
+			state[r.Key] = r.State
+			if err := journal.set(r.Key, r.State); err != nil {
+				log.Print(err)
+			}
+		case r.Err != nil:
+			delete(state, r.Key)
+			if err := journal.remove(r.Key); err != nil {
+				log.Print(err)
+			}
+		}
+		done(r)
+	}
+	// fold the journal into the state file
+	if err := journal.Close(); err != nil {
+		log.Print(err)
+	}
+	if err := state.save(dir); err != nil {
+		log.Print(err)
+	}
+	return outcomes
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"soxy/pipeline"
+)
+
+// stateFile records in the output folder which inputs have been rendered
+// with which config, so an interrupted run can be resumed.  The files
+// finished since it was last written are in the journal next to it.
+const (
+	stateFile    = ".soxy-state.json"
+	stateJournal = ".soxy-state.log"
+)
+
+// fileState is what a finished output was made from.
+type fileState struct {
+	InputHash  string `json:"input"`
+	ConfigHash string `json:"config"`
+}
+
+// runState maps the stateKey of an output to its state.
+type runState map[string]fileState
+
+// stateKey is the entry of the output out in the runState kept in dir: its
+// slash separated path below dir, so the state survives the folder being
+// moved or renamed, or for an output outside dir its cleaned absolute
+// path.  Outputs with the same name in different folders never share one.
+func stateKey(dir, out string) string {
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return filepath.Clean(out)
+	}
+	if absDir, err := filepath.Abs(dir); err == nil && within(abs, absDir) {
+		if rel, err := filepath.Rel(absDir, abs); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return abs
+}
+
+// journalEntry is one line of the journal: the new state of a file, or
+// its removal.
+type journalEntry struct {
+	File string `json:"file"`
+	fileState
+	Removed bool `json:"removed,omitempty"`
+}
+
+// loadState reads the state file in dir and replays the journal.  Missing
+// files are an empty state.  A last journal line cut short by a crash is
+// ignored.
+func loadState(dir string) (runState, error) {
+	state := runState{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("%s: %v", stateFile, err)
+		}
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, stateJournal))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("%s line %d: %v", stateJournal, i+1, err)
+		}
+		if e.Removed {
+			delete(state, e.File)
+		} else {
+			state[e.File] = e.fileState
+		}
+	}
+	return state, nil
+}
+
+// save writes the state to dir, replacing the old file only once the new
+// one is complete, and clears the journal it now includes.
+func (s runState) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, stateFile)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, stateJournal)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// journal appends the changes to a runState to the journal in its folder,
+// so recording a finished file is one short write however large the batch.
+// Its methods do nothing on a nil *journal.
+type journal struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openJournal(dir string) (*journal, error) {
+	f, err := os.OpenFile(filepath.Join(dir, stateJournal), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// set records the state of the output of rel.
+func (j *journal) set(rel string, st fileState) error {
+	if j == nil {
+		return nil
+	}
+	return j.enc.Encode(journalEntry{File: rel, fileState: st})
+}
+
+// remove records that rel has no up to date output.
+func (j *journal) remove(rel string) error {
+	if j == nil {
+		return nil
+	}
+	return j.enc.Encode(journalEntry{File: rel, Removed: true})
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashConfig returns the SHA-256 of the decoded config, so formatting and
+// comments in the file don't count as changes.
+func hashConfig(c pipeline.Config) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// prepareOutPath makes sure dir exists.  A folder that already has files
+// in it is only used when resuming, and only deleted when clean is set and
+// none of inputs, the paths the run reads, is dir or inside it.
+func prepareOutPath(dir string, resume, clean bool, inputs []string) error {
+	entries, err := ioutil.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case len(entries) == 0 || resume:
+	case clean:
+		if err := checkClean(dir, inputs); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s is not empty: use -resume to continue a run or -clean to delete it first", dir)
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// checkClean returns an error when deleting dir would delete one of
+// inputs.  Empty inputs are ignored.
+func checkClean(dir string, inputs []string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, in := range inputs {
+		if in == "" {
+			continue
+		}
+		path, err := filepath.Abs(in)
+		if err != nil {
+			return err
+		}
+		if within(path, abs) {
+			return fmt.Errorf("refusing -clean: %s is in the output folder %s", in, dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state, err := loadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state) != 0 {
+		t.Fatalf("empty folder: got %v", state)
+	}
+
+	a, b, c := stateKey(dir, filepath.Join(dir, "a.wav")), stateKey(dir, filepath.Join(dir, "sub", "a.wav")), stateKey(dir, filepath.Join(dir, "c.wav"))
+	state = runState{a: {"in-a", "cfg"}, b: {"in-b", "cfg"}}
+	if err := state.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// files finished after the save go to the journal
+	j, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		key    string
+		st     fileState
+		remove bool
+	}{
+		{c, fileState{"in-c", "cfg"}, false},
+		{a, fileState{"in-a2", "cfg2"}, false},
+		{b, fileState{}, true},
+	}
+	for _, s := range steps {
+		if s.remove {
+			err = j.remove(s.key)
+		} else {
+			err = j.set(s.key, s.st)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := runState{a: {"in-a2", "cfg2"}, c: {"in-c", "cfg"}}
+	got, err := loadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed journal: got %v, want %v", got, want)
+	}
+
+	// saving folds the journal into the state file
+	if err := got.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, stateJournal)); !os.IsNotExist(err) {
+		t.Errorf("journal left after save: %v", err)
+	}
+	if got, err = loadState(dir); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("after save: got %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestStateKey(t *testing.T) {
+	out, err := filepath.Abs(filepath.Join("data", "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir, file string
+		want      string
+	}{
+		{out, filepath.Join(out, "a.wav"), "a.wav"},
+		{out, filepath.Join(out, "sub", "a.wav"), "sub/a.wav"},
+		{out + string(filepath.Separator), filepath.Join(out, "x", "..", "a.wav"), "a.wav"},
+		{filepath.Join("data", "out"), filepath.Join("data", "out", "a.wav"), "a.wav"},
+		{out, filepath.Join(out, "..", "elsewhere", "a.wav"), filepath.Join(filepath.Dir(out), "elsewhere", "a.wav")},
+		{out, filepath.Join(out+"2", "a.wav"), filepath.Join(out+"2", "a.wav")},
+	}
+	for _, tt := range tests {
+		if got := stateKey(tt.dir, tt.file); got != tt.want {
+			t.Errorf("stateKey(%s, %s) = %s, want %s", tt.dir, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestStateMovedFolder(t *testing.T) {
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir, moved := filepath.Join(root, "out"), filepath.Join(root, "renamed")
+	elsewhere := filepath.Join(root, "elsewhere", "b.wav")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	state := runState{
+		stateKey(dir, filepath.Join(dir, "sub", "a.wav")): {"in-a", "cfg"},
+		stateKey(dir, elsewhere):                          {"in-b", "cfg"},
+	}
+	if err := state.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	j, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.set(stateKey(dir, filepath.Join(dir, "c.wav")), fileState{"in-c", "cfg"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadState(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]fileState{
+		filepath.Join(moved, "sub", "a.wav"): {"in-a", "cfg"},
+		filepath.Join(moved, "c.wav"):        {"in-c", "cfg"},
+		elsewhere:                            {"in-b", "cfg"},
+	} {
+		if st, ok := got[stateKey(moved, file)]; !ok || st != want {
+			t.Errorf("%s: got %v, %v after the rename, want %v", file, st, ok, want)
+		}
+	}
+}
+
+func TestLoadStateJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	line := `{"file":"/out/a.wav","input":"in","config":"cfg"}` + "\n"
+	tests := []struct {
+		name    string
+		journal string
+		ok      bool
+	}{
+		{"complete", line, true},
+		{"cut short", line + `{"file":"/out/b.wav","inp`, true},
+		{"bad line", `{"file":` + "\n" + line, false},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, stateJournal), []byte(tt.journal), 0644); err != nil {
+			t.Fatal(err)
+		}
+		state, err := loadState(dir)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := (runState{"/out/a.wav": {"in", "cfg"}}); !reflect.DeepEqual(state, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, state, want)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, stateFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadState(dir); err == nil {
+		t.Error("bad state file: expected an error")
+	}
+}
+
+func TestPrepareOutPathClean(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		inputs []string
+		ok     bool
+	}{
+		{"apart", "out", []string{"in", "in/a.wav", "list.csv"}, true},
+		{"inside inPath", "in/out", []string{"in", "in/a.wav"}, true},
+		{"no inputs", "out", nil, true},
+		{"same as inPath", "in", []string{"in", "in/a.wav"}, false},
+		{"same as inPath with a slash", "in/", []string{"in"}, false},
+		{"holds inPath", ".", []string{"in"}, false},
+		{"holds a manifest input", "out", []string{"", "list.csv", "out/old/a.wav"}, false},
+		{"holds the manifest", "out", []string{"", "out/list.csv"}, false},
+	}
+	for _, tt := range tests {
+		root, err := ioutil.TempDir("", "soxy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"in/a.wav", "in/out/a.wav", "out/old/a.wav", "out/list.csv", "list.csv"} {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var inputs []string
+		for _, in := range tt.inputs {
+			if in != "" {
+				in = filepath.Join(root, filepath.FromSlash(in))
+			}
+			inputs = append(inputs, in)
+		}
+		out := filepath.Join(root, filepath.FromSlash(tt.out))
+
+		err = prepareOutPath(out, false, true, inputs)
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case !tt.ok && err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		// a refused clean deletes nothing
+		_, inErr := os.Stat(filepath.Join(root, "in", "a.wav"))
+		_, outErr := os.Stat(filepath.Join(root, "out", "old", "a.wav"))
+		if !tt.ok && (inErr != nil || outErr != nil) {
+			t.Errorf("%s: files deleted: %v, %v", tt.name, inErr, outErr)
+		}
+		if tt.ok {
+			if entries, err := ioutil.ReadDir(out); err != nil || len(entries) != 0 {
+				t.Errorf("%s: %s not emptied: %v %v", tt.name, tt.out, entries, err)
+			}
+		}
+		os.RemoveAll(root)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"soxy/pipeline"
+	"strings"
+	"time"
+)
+
+// watcher turns the WAV files that appear in a folder into jobs once they
+// have stopped growing.
+type watcher struct {
+	in, out      string
+	done, failed string
+	settle       time.Duration
+	c            pipeline.Config
+	hash         string
+
+	// seen holds the files still being written, busy the ones queued or
+	// being processed and stuck the ones whose original couldn't be moved
+	// away, so they aren't processed again.
+	seen  map[string]seenFile
+	busy  map[string]bool
+	stuck map[string]bool
+}
+
+// seenFile is the last size and modification time seen for a file and
+// when they last changed.
+type seenFile struct {
+	size  int64
+	mod   time.Time
+	since time.Time
+}
+
+func cmdWatch(args []string) int {
+	fs := newFlagSet("watch")
+	fs.StringVar(&inPath, "inPath", "", "folder to watch for new waves")
+	fs.StringVar(&outPath, "outPath", "", "output folder")
+	fs.StringVar(&inConfig, "c", "", "path to config")
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "Number of go routines to use.")
+	fs.BoolVar(&recursive, "recursive", false, "also watch the sub folders of inPath and mirror them in outPath")
+	fs.Var(&include, "include", "comma separated glob patterns of files to process (default *.wav)")
+	fs.Var(&exclude, "exclude", "comma separated glob patterns of files and folders to skip")
+	fs.BoolVar(&spectro, "spectro", false, "also create spectrograms")
+	fs.Var(&memory, "memory", "memory budget for the files processed at once, such as 8G; a file estimated to need more is processed alone (default no limit)")
+	fs.StringVar(&progressPath, "progress", "", "write JSON lines progress events to this file, - for stdout")
+	done := fs.String("done", "", "folder the originals are moved to once processed (default inPath/done)")
+	failed := fs.String("failed", "", "folder the originals are moved to when they fail (default inPath/failed)")
+	interval := fs.Duration("interval", time.Second, "how often inPath is scanned")
+	settle := fs.Duration("settle", 2*time.Second, "how long a file must stay the same size before it is processed")
+	fs.Parse(args)
+	if fs.NArg() > 0 || inPath == "" || outPath == "" || inConfig == "" {
+		fs.Usage()
+		return 2
+	}
+	if samePath(inPath, outPath) {
+		log.Fatalf("outPath %s is the watched folder", outPath)
+	}
+	if *done == "" {
+		*done = filepath.Join(inPath, "done")
+	}
+	if *failed == "" {
+		*failed = filepath.Join(inPath, "failed")
+	}
+	if len(include) == 0 {
+		include = patterns{"*.wav"}
+	}
+
+	c, err := pipeline.LoadConfig(inConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	hash, err := hashConfig(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := &watcher{
+		in:     inPath,
+		out:    outPath,
+		done:   *done,
+		failed: *failed,
+		settle: *settle,
+		c:      c,
+		hash:   hash,
+		seen:   map[string]seenFile{},
+		busy:   map[string]bool{},
+		stuck:  map[string]bool{},
+	}
+	if progressPath != "" {
+		if progress, err = openEvents(progressPath); err != nil {
+			log.Fatal(err)
+		}
+		defer progress.Close()
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	return w.run(ctx, *interval)
+}
+
+// run scans the folder every interval and feeds the worker pool until ctx
+// is cancelled.  The files being processed then are cancelled and left
+// where they are, to be picked up again by the next run.  It returns 1
+// when a file failed or couldn't be moved, or when the folder couldn't
+// be scanned, either at the start or up to the end.
+func (w *watcher) run(ctx context.Context, interval time.Duration) int {
+	// a folder that can't be read at all is a mistake, not a hiccup
+	if _, err := w.scan(time.Now()); err != nil {
+		log.Print(err)
+		return 1
+	}
+	jobs := make(chan job)
+	results := make(chan result)
+	for idx := 0; idx < workers; idx++ {
+		go worker(ctx, jobs, results, nil)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("watching %s", w.in)
+	adm := &admission{limit: int64(memory)}
+	var pending []job
+	inFlight, processed, failures := 0, 0, 0
+	// scanErr is the error of the last scan, logged when it changes
+	// rather than on every tick
+	var scanErr error
+	finish := func(r result) {
+		inFlight--
+		delete(w.busy, r.Rel)
+		if r.Memory > 0 {
+			adm.release(r.Memory)
+		}
+		if r.Canceled {
+			log.Printf("%s: cancelled", r.File)
+		} else if w.finish(r) {
+			processed++
+		} else {
+			failures++
+		}
+	}
+	for stop := false; !stop; {
+		var send chan job
+		var next job
+		if len(pending) > 0 && adm.fits(pending[0].Memory) {
+			send, next = jobs, pending[0]
+		}
+		select {
+		case <-ctx.Done():
+			stop = true
+		case now := <-ticker.C:
+			ready, err := w.scan(now)
+			switch {
+			case err != nil && (scanErr == nil || err.Error() != scanErr.Error()):
+				log.Printf("%v, retrying every %v", err, interval)
+			case err == nil && scanErr != nil:
+				log.Printf("scanning %s again", w.in)
+			}
+			scanErr = err
+			for _, rel := range ready {
+				j := job{InFile: filepath.Join(w.in, rel), OutFile: filepath.Join(w.out, rel), Rel: rel, C: w.c, ConfigHash: w.hash}
+				if memory > 0 {
+					j.Memory = footprint(j)
+				}
+				pending = append(pending, j)
+			}
+		case send <- next:
+			pending = pending[1:]
+			inFlight++
+			if next.Memory > 0 {
+				adm.admit(next.Memory)
+			}
+		case r := <-results:
+			finish(r)
+		}
+	}
+	close(jobs)
+	for inFlight > 0 {
+		finish(<-results)
+	}
+	fmt.Fprintf(os.Stderr, "%d files processed, %d failed\n", processed, failures)
+	if failures > 0 || scanErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// scan returns the files that have kept their size and modification time
+// for the settle time and aren't queued yet, and marks them busy.
+func (w *watcher) scan(now time.Time) ([]string, error) {
+	files, err := discover(w.in, recursive, include, exclude, w.out)
+	if err != nil {
+		return nil, err
+	}
+	var ready []string
+	found := map[string]bool{}
+	for _, rel := range files {
+		path := filepath.Join(w.in, rel)
+		if w.busy[rel] || w.stuck[rel] || within(path, w.done) || within(path, w.failed) {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		found[rel] = true
+		prev, ok := w.seen[rel]
+		if !ok || prev.size != fi.Size() || !prev.mod.Equal(fi.ModTime()) {
+			w.seen[rel] = seenFile{size: fi.Size(), mod: fi.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(prev.since) >= w.settle {
+			delete(w.seen, rel)
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(w.out, rel)), 0755); err != nil {
+				log.Print(err)
+				continue
+			}
+			w.busy[rel] = true
+			ready = append(ready, rel)
+		}
+	}
+	// forget files that went away before they settled
+	for rel := range w.seen {
+		if !found[rel] {
+			delete(w.seen, rel)
+		}
+	}
+	return ready, nil
+}
+
+// finish moves the original of a finished job to the done or failed
+// folder and reports whether it succeeded.  A failed file gets the error
+// written next to it in a .error file.  An original that can't be moved
+// counts as failed and is left alone until soxy watch is restarted.
+func (w *watcher) finish(r result) bool {
+	dir := w.done
+	if r.Err != nil {
+		dir = w.failed
+		log.Print(r.Err)
+	} else {
+		logNotes(r.File, r.Notes)
+		log.Printf("%s: done", r.File)
+	}
+	dest := filepath.Join(dir, r.Rel)
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err == nil {
+		err = os.Rename(r.File, dest)
+	}
+	if err != nil {
+		log.Printf("%v: %s is left where it is and not processed again", err, r.File)
+		w.stuck[r.Rel] = true
+		return false
+	}
+	if r.Err != nil {
+		if err := ioutil.WriteFile(dest+".error", []byte(r.Err.Error()+"\n"), 0644); err != nil {
+			log.Print(err)
+		}
+	}
+	return r.Err == nil
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestWatcher returns a watcher of root/in writing to root/out with a
+// settle time of 2s.
+func newTestWatcher(t *testing.T, root string) *watcher {
+	t.Helper()
+	for _, dir := range []string{"in", "out"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := filepath.Join(root, "in")
+	return &watcher{
+		in:     in,
+		out:    filepath.Join(root, "out"),
+		done:   filepath.Join(in, "done"),
+		failed: filepath.Join(in, "failed"),
+		settle: 2 * time.Second,
+		seen:   map[string]seenFile{},
+		busy:   map[string]bool{},
+		stuck:  map[string]bool{},
+	}
+}
+
+// setWatchFlags sets the flags scan reads and returns a func restoring
+// them.
+func setWatchFlags() func() {
+	oldInclude, oldExclude, oldRecursive := include, exclude, recursive
+	include, exclude, recursive = patterns{"*.wav"}, nil, true
+	return func() {
+		include, exclude, recursive = oldInclude, oldExclude, oldRecursive
+	}
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestWatcherScan(t *testing.T) {
+	defer setWatchFlags()()
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	w := newTestWatcher(t, root)
+	a, b := filepath.Join(w.in, "a.wav"), filepath.Join(w.in, "b.wav")
+	t0 := time.Unix(1000, 0)
+
+	steps := []struct {
+		name   string
+		change func()
+		after  time.Duration
+		want   []string
+	}{
+		{"new files", func() { appendFile(t, a, "x"); appendFile(t, b, "x") }, 0, nil},
+		{"a grows", func() { appendFile(t, a, "x") }, 3 * time.Second, []string{"b.wav"}},
+		{"a settling", nil, 4 * time.Second, nil},
+		{"a settled", nil, 5 * time.Second, []string{"a.wav"}},
+		{"nothing again", nil, 10 * time.Second, nil},
+	}
+	for _, s := range steps {
+		if s.change != nil {
+			s.change()
+		}
+		got, err := w.scan(t0.Add(s.after))
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: got %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	// a file that goes away before it settles is forgotten
+	c := filepath.Join(w.in, "c.wav")
+	appendFile(t, c, "x")
+	if _, err := w.scan(t0.Add(20 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.seen["c.wav"]; !ok {
+		t.Fatal("c.wav not seen")
+	}
+	os.Remove(c)
+	if _, err := w.scan(t0.Add(21 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.seen) != 0 {
+		t.Errorf("seen = %v after c.wav went away", w.seen)
+	}
+
+	// folders the originals are moved to aren't scanned
+	if err := os.MkdirAll(w.done, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, filepath.Join(w.done, "d.wav"), "x")
+	for _, after := range []time.Duration{30 * time.Second, 40 * time.Second} {
+		if got, err := w.scan(t0.Add(after)); err != nil || len(got) != 0 {
+			t.Errorf("done folder: got %v, %v", got, err)
+		}
+	}
+
+	os.RemoveAll(w.in)
+	if _, err := w.scan(t0.Add(50 * time.Second)); err == nil {
+		t.Error("missing folder: expected an error")
+	}
+}
+
+func TestWatcherFinish(t *testing.T) {
+	defer setWatchFlags()()
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	w := newTestWatcher(t, root)
+
+	tests := []struct {
+		rel  string
+		err  error
+		ok   bool
+		dest string
+	}{
+		{"a.wav", nil, true, "done/a.wav"},
+		{"sub/b.wav", nil, true, "done/sub/b.wav"},
+		{"c.wav", errors.New("c.wav: decode: not a wav"), false, "failed/c.wav"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(w.in, filepath.FromSlash(tt.rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		appendFile(t, path, "x")
+		if ok := w.finish(result{File: path, Rel: filepath.FromSlash(tt.rel), Err: tt.err}); ok != tt.ok {
+			t.Errorf("%s: finish = %v, want %v", tt.rel, ok, tt.ok)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: still in the folder: %v", tt.rel, err)
+		}
+		dest := filepath.Join(w.in, filepath.FromSlash(tt.dest))
+		if _, err := os.Stat(dest); err != nil {
+			t.Errorf("%s: %v", tt.rel, err)
+		}
+		msg, err := ioutil.ReadFile(dest + ".error")
+		switch {
+		case tt.err == nil && !os.IsNotExist(err):
+			t.Errorf("%s: .error file for a processed file: %v", tt.rel, err)
+		case tt.err != nil && string(msg) != tt.err.Error()+"\n":
+			t.Errorf("%s: .error holds %q, %v", tt.rel, msg, err)
+		}
+	}
+	if len(w.stuck) != 0 {
+		t.Errorf("stuck = %v", w.stuck)
+	}
+}
+
+func TestWatcherStuck(t *testing.T) {
+	defer setWatchFlags()()
+	root, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	w := newTestWatcher(t, root)
+	// done can't be created below a file
+	blocker := filepath.Join(root, "blocker")
+	appendFile(t, blocker, "x")
+	w.done = filepath.Join(blocker, "done")
+
+	a := filepath.Join(w.in, "a.wav")
+	appendFile(t, a, "x")
+	t0 := time.Unix(1000, 0)
+	w.scan(t0)
+	ready, err := w.scan(t0.Add(3 * time.Second))
+	if err != nil || !reflect.DeepEqual(ready, []string{"a.wav"}) {
+		t.Fatalf("got %v, %v", ready, err)
+	}
+	// what run does with the result
+	delete(w.busy, "a.wav")
+	if w.finish(result{File: a, Rel: "a.wav"}) {
+		t.Error("finish = true for an original that wasn't moved")
+	}
+	if !w.stuck["a.wav"] {
+		t.Fatalf("stuck = %v", w.stuck)
+	}
+	if _, err := os.Stat(a); err != nil {
+		t.Fatal(err)
+	}
+	for i := 4; i < 10; i++ {
+		if got, err := w.scan(t0.Add(time.Duration(i) * time.Second)); err != nil || len(got) != 0 {
+			t.Errorf("scan %d: got %v, %v", i, got, err)
+		}
+	}
+}
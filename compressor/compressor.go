@@ -8,13 +8,23 @@ import (
 	"github.com/go-audio/audio"
 )
 
+// Stereo link modes selected by Compressor.StereoLink.
+const (
+	// Unlinked compresses every channel from its own detector.
+	Unlinked = iota
+	// LinkMax drives every channel from the loudest detector.
+	LinkMax
+	// LinkAverage drives every channel from the mean linear level of the detectors.
+	LinkAverage
+	// LinkRMS drives every channel from the root mean square of the detector levels.
+	LinkRMS
+)
+
 // Compressor --
 type Compressor struct {
-	L envelopedetector.EnvelopeDetector
-	R envelopedetector.EnvelopeDetector
-
-	LDelay delay.Delay
-	RDelay delay.Delay
+	// One detector and look ahead delay per interleaved channel.
+	Detectors []envelopedetector.EnvelopeDetector
+	Delays    []delay.Delay
 
 	InputGain      float64
 	Threshold      float64
@@ -28,6 +38,8 @@ type Compressor struct {
 	ProcessorType  int
 	SampleRate     float64
 	Analog         bool
+
+	levels []float64
 }
 
 func lagrpol(x []float64, y []float64, n int, xbar float64) float64 {
@@ -75,27 +87,6 @@ func multMat(a []float64, b []float64) []float64 {
 
 // Compress will compress the signal
 func Compress(buf *audio.FloatBuffer, ratio float64, attackTime float64, releaseTime float64, threshold float64, inGain float64, outGain float64, sampleRate float64, lookAheadDelay float64, knee float64) {
-	/*
-		c.Threshold = -5.0
-		c.Ratio = 4.0
-		c.AttackTime = 0.1
-		c.ReleaseTime = 0.1
-		c.OutputGain = 1.0
-		c.InputGain = 1.0
-		c.SampleRate = 16000.0
-		c.LookAheadDelay = 2000
-		c.Knee = 20.0
-		c.L.DetectMode = 1
-		c.R.DetectMode = 1
-
-		c.L.Init(c.SampleRate, c.AttackTime, c.ReleaseTime, false, 2, true)
-		c.R.Init(c.SampleRate, c.AttackTime, c.ReleaseTime, false, 2, true)
-
-		c.LDelay.Init(int(0.3 * c.SampleRate))
-		c.RDelay.Init(int(0.3 * c.SampleRate))
-		c.LDelay.SetDelayInMillis(c.LookAheadDelay)
-		c.RDelay.SetDelayInMillis(c.LookAheadDelay)
-	*/
 	c := Compressor{
 		Threshold:      threshold,
 		Ratio:          ratio,
@@ -103,59 +94,124 @@ func Compress(buf *audio.FloatBuffer, ratio float64, attackTime float64, release
 		OutputGain:     outGain,
 		AttackTime:     attackTime,
 		ReleaseTime:    releaseTime,
-		SampleRate:     sampleRate,
 		LookAheadDelay: lookAheadDelay,
 		Knee:           knee,
 	}
-	c.L.Init(c.SampleRate, c.AttackTime, c.ReleaseTime, false, 2, true)
-	c.R.Init(c.SampleRate, c.AttackTime, c.ReleaseTime, false, 2, true)
+	numChannels := 1
+	if buf.Format != nil {
+		numChannels = buf.Format.NumChannels
+	}
+	c.Prepare(sampleRate, numChannels)
+	c.ProcessBlock(buf)
+}
 
-	c.LDelay.Init(int(0.3 * c.SampleRate))
-	c.RDelay.Init(int(0.3 * c.SampleRate))
-	c.LDelay.SetDelayInMillis(c.LookAheadDelay)
-	c.RDelay.SetDelayInMillis(c.LookAheadDelay)
-	for i := 0; i < len(buf.Data); i++ {
-		inSample := buf.Data[i]
-		inputGain := math.Pow(10.0, c.InputGain/20.0)
-		outputGain := math.Pow(10.0, c.OutputGain/20.0)
+// Init sets up the detectors and look ahead delays from the current
+// settings.  SampleRate must be set before calling Init.  A compressor
+// that was never prepared gets a single channel.
+func (c *Compressor) Init() {
+	if len(c.Detectors) == 0 {
+		c.setChannels(1)
+	}
+	for ch := range c.Detectors {
+		c.Detectors[ch].Init(c.SampleRate, c.AttackTime, c.ReleaseTime, c.Analog, 2, true)
+		c.Delays[ch].Init(int(0.3 * c.SampleRate))
+		c.Delays[ch].SetDelayInMillis(c.LookAheadDelay)
+	}
+}
 
-		XNL := inputGain * inSample
+func (c *Compressor) setChannels(numChannels int) {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	c.Detectors = make([]envelopedetector.EnvelopeDetector, numChannels)
+	c.Delays = make([]delay.Delay, numChannels)
+	c.levels = make([]float64, numChannels)
+}
 
-		leftDetector := c.L.Detect(XNL)
-		// rightDetector := leftDetector
+// Prepare sets the sample rate and channel count and initializes the
+// compressor.
+func (c *Compressor) Prepare(sampleRate float64, numChannels int) {
+	c.SampleRate = sampleRate
+	c.setChannels(numChannels)
+	c.Init()
+}
 
-		linkDetector := leftDetector
+// ProcessBlock compresses the interleaved buffer in place.  Depending on
+// StereoLink every channel is either compressed on its own or all channels
+// share a gain derived from the linked detector value.
+func (c *Compressor) ProcessBlock(buf *audio.FloatBuffer) {
+	inputGain := math.Pow(10.0, c.InputGain/20.0)
+	outputGain := math.Pow(10.0, c.OutputGain/20.0)
+	numChannels := len(c.Detectors)
+	for i := 0; i+numChannels <= len(buf.Data); i += numChannels {
+		frame := buf.Data[i : i+numChannels]
+		for ch, in := range frame {
+			c.levels[ch] = c.Detectors[ch].Detect(inputGain * in)
+		}
 		FGN := 1.0
+		if c.StereoLink != Unlinked {
+			// set final arg to true to limit
+			FGN = c.calcCompressorGain(c.link(), c.Threshold, c.Ratio, c.Knee, false)
+		}
+		for ch, in := range frame {
+			if c.StereoLink == Unlinked {
+				FGN = c.calcCompressorGain(c.levels[ch], c.Threshold, c.Ratio, c.Knee, false)
+			}
+			lookAheadOut := c.Delays[ch].ProcessAudio(in)
+			frame[ch] = FGN * lookAheadOut * outputGain
+		}
+	}
+}
+
+// link combines the per channel detector levels (in dB) into one value.
+func (c *Compressor) link() float64 {
+	switch c.StereoLink {
+	case LinkMax:
+		linkDetector := c.levels[0]
+		for _, level := range c.levels[1:] {
+			linkDetector = math.Max(linkDetector, level)
+		}
+		return linkDetector
+	case LinkRMS:
+		sum := 0.0
+		for _, level := range c.levels {
+			sum += math.Pow(10.0, level/10.0)
+		}
+		return 10.0 * math.Log10(sum/float64(len(c.levels)))
+	default:
+		sum := 0.0
+		for _, level := range c.levels {
+			sum += math.Pow(10.0, level/20.0)
+		}
+		return 20.0 * math.Log10(sum/float64(len(c.levels)))
+	}
+}
 
-		// linkDetector = 0.5 * (math.Pow(10.0, leftDetector/20.0) + math.Pow(10.0, rightDetector/20.0))
-		// linkDetector = 20.0 * math.Log10(linkDetector)
+// Reset clears the envelopes and the look ahead delays.
+func (c *Compressor) Reset() {
+	c.Init()
+}
 
-		// set final arg to true to limit
-		FGN = c.calcCompressorGain(linkDetector, c.Threshold, c.Ratio, c.Knee, false)
-		lookAheadOut := c.LDelay.ProcessAudio(inSample)
-		buf.Data[i] = FGN * lookAheadOut * outputGain
+// Latency is the look ahead delay in samples.
+func (c *Compressor) Latency() int {
+	if len(c.Delays) == 0 {
+		return 0
 	}
+	return int(c.Delays[0].DelayInSamples)
 }
 
-// Process a sample
+// Process compresses a single sample of a mono signal.
 func (c *Compressor) Process(inSample float64) float64 {
 	inputGain := math.Pow(10.0, c.InputGain/20.0)
 	outputGain := math.Pow(10.0, c.OutputGain/20.0)
 
 	XNL := inputGain * inSample
 
-	leftDetector := c.L.Detect(XNL)
-	// rightDetector := leftDetector
-
-	linkDetector := leftDetector
-	FGN := 1.0
-
-	// linkDetector = 0.5 * (math.Pow(10.0, leftDetector/20.0) + math.Pow(10.0, rightDetector/20.0))
-	// linkDetector = 20.0 * math.Log10(linkDetector)
+	linkDetector := c.Detectors[0].Detect(XNL)
 
 	// set final arg to true to limit
-	FGN = c.calcCompressorGain(linkDetector, c.Threshold, c.Ratio, c.Knee, false)
-	lookAheadOut := c.LDelay.ProcessAudio(inSample)
+	FGN := c.calcCompressorGain(linkDetector, c.Threshold, c.Ratio, c.Knee, false)
+	lookAheadOut := c.Delays[0].ProcessAudio(inSample)
 	outputSample := FGN * lookAheadOut * outputGain
 	return outputSample
 }
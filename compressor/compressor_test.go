@@ -7,6 +7,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/go-audio/audio"
 	wav "github.com/youpy/go-wav"
 )
 
@@ -25,9 +26,7 @@ func TestCompressor(t *testing.T) {
 	c.OutputGain = 0.0
 	c.InputGain = 0.0
 	c.SampleRate = 16000.0
-	c.L.DetectMode = 2
-	c.R.DetectMode = 2
-	c.L.AnalogTC = true
+	c.Analog = true
 
 	c.Init()
 	w := wav.NewReader(f)
@@ -36,6 +35,36 @@ func TestCompressor(t *testing.T) {
 	writeWav("out.wav", *results, 16000, 16, 1)
 }
 
+func TestStereoLink(t *testing.T) {
+	// A loud left channel and a quiet right channel.
+	buf := &audio.FloatBuffer{Format: &audio.Format{NumChannels: 2, SampleRate: 16000}}
+	for i := 0; i < 16000; i++ {
+		buf.Data = append(buf.Data, 0.9, 0.01)
+	}
+	run := func(link int) *audio.FloatBuffer {
+		out := &audio.FloatBuffer{Format: buf.Format, Data: append([]float64(nil), buf.Data...)}
+		c := &Compressor{Threshold: -20.0, Ratio: 10.0, AttackTime: 1.0, ReleaseTime: 100.0, StereoLink: link}
+		c.Prepare(16000.0, 2)
+		c.ProcessBlock(out)
+		return out
+	}
+
+	unlinked := run(Unlinked)
+	if r := unlinked.Data[len(unlinked.Data)-1]; math.Abs(r-0.01) > 1e-9 {
+		t.Errorf("unlinked right channel changed: got %v want 0.01", r)
+	}
+	for _, link := range []int{LinkMax, LinkAverage, LinkRMS} {
+		out := run(link)
+		l, r := out.Data[len(out.Data)-2], out.Data[len(out.Data)-1]
+		if r >= 0.01 {
+			t.Errorf("link %d: right channel not reduced: %v", link, r)
+		}
+		if gl, gr := l/0.9, r/0.01; math.Abs(gl-gr) > 1e-9 {
+			t.Errorf("link %d: channels got different gains: %v and %v", link, gl, gr)
+		}
+	}
+}
+
 // func processSample(in float64, , bitDepth float64, channel int) float64 {
 // 	for _, filt := range fx {
 // 		in = filt.Process(in, channel)
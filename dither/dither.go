@@ -0,0 +1,109 @@
+package dither
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Dither types
+const (
+	None = "none"
+	TPDF = "tpdf"
+)
+
+// shapers holds the error feedback coefficients of the noise shaping
+// filters.  "simple" is a first order high pass, "lipshitz" is the 5 tap
+// filter from Lipshitz, Vanderkooy and Wannamaker and "fweighted" is the 9
+// tap F-weighted filter from Wannamaker.  The last two are designed for
+// 44.1 kHz and push the noise towards the top of the spectrum.
+var shapers = map[string][]float64{
+	"none":      nil,
+	"simple":    {1},
+	"lipshitz":  {2.033, -2.165, 1.959, -1.590, 0.6149},
+	"fweighted": {2.412, -3.370, 3.937, -4.174, 3.353, -2.205, 1.281, -0.569, 0.0847},
+}
+
+// Quantizer converts interleaved floats where 1.0 is full scale to integers
+// of BitDepth bits, optionally adding TPDF dither and shaping the
+// quantization noise.  The noise comes from a seeded generator so the same
+// input and seed always give the same output.
+type Quantizer struct {
+	BitDepth    int
+	NumChannels int
+
+	scale  float64
+	dither bool
+	shaper []float64
+	// errs holds the recent quantization error of every channel, newest
+	// first.
+	errs [][]float64
+	rng  *rand.Rand
+}
+
+// New returns a quantizer.  dither is "none" or "tpdf" and shaping is one
+// of "none", "simple", "lipshitz" or "fweighted"; empty strings mean
+// "none".
+func New(bitDepth, numChannels int, dither, shaping string, seed int64) (*Quantizer, error) {
+	if bitDepth < 2 || bitDepth > 32 {
+		return nil, fmt.Errorf("dither: can't quantize to %d bits", bitDepth)
+	}
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	q := &Quantizer{
+		BitDepth:    bitDepth,
+		NumChannels: numChannels,
+		scale:       math.Pow(2, float64(bitDepth-1)),
+		rng:         rand.New(rand.NewSource(seed)),
+	}
+	switch strings.ToLower(dither) {
+	case "", None:
+	case TPDF:
+		q.dither = true
+	default:
+		return nil, fmt.Errorf("dither: unknown dither %q (want none or tpdf)", dither)
+	}
+	if shaping == "" {
+		shaping = "none"
+	}
+	shaper, ok := shapers[strings.ToLower(shaping)]
+	if !ok {
+		return nil, fmt.Errorf("dither: unknown noise shaping %q (want none, simple, lipshitz or fweighted)", shaping)
+	}
+	q.shaper = shaper
+	q.errs = make([][]float64, numChannels)
+	for ch := range q.errs {
+		q.errs[ch] = make([]float64, len(shaper))
+	}
+	return q, nil
+}
+
+// Quantize converts in to out, which must be at least as long.
+func (q *Quantizer) Quantize(in []float64, out []int) {
+	min, max := -q.scale, q.scale-1
+	for i, x := range in {
+		ch := i % q.NumChannels
+		errs := q.errs[ch]
+
+		v := x * q.scale
+		for k, c := range q.shaper {
+			v -= c * errs[k]
+		}
+		y := v
+		if q.dither {
+			// the sum of two uniform values is triangular over +-1 LSB
+			y += q.rng.Float64() - q.rng.Float64()
+		}
+		y = math.Max(min, math.Min(max, math.Round(y)))
+		out[i] = int(y)
+
+		if len(errs) > 0 {
+			copy(errs[1:], errs)
+			// clipping produces errors the filter can't shape, limit them
+			// so the feedback loop stays stable
+			errs[0] = math.Max(-2, math.Min(2, y-v))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dither
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuantizeRounds(t *testing.T) {
+	q, err := New(16, 1, None, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := []float64{0, 0.5, -1, 1, 2, 1.4 / 32768}
+	out := make([]int, len(in))
+	q.Quantize(in, out)
+	want := []int{0, 16384, -32768, 32767, 32767, 1}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Fatalf("got %v, want %v", out, want)
+		}
+	}
+}
+
+func TestTPDF(t *testing.T) {
+	// A constant a quarter LSB above zero averages out to a quarter LSB
+	// with dither and never lands more than 2 LSB away.
+	in := make([]float64, 100000)
+	for i := range in {
+		in[i] = 0.25 / 128
+	}
+	out := make([]int, len(in))
+	q, _ := New(8, 2, TPDF, "", 1)
+	q.Quantize(in, out)
+	sum := 0
+	for _, v := range out {
+		if v < -2 || v > 2 {
+			t.Fatalf("sample %d is too far off", v)
+		}
+		sum += v
+	}
+	if mean := float64(sum) / float64(len(out)); math.Abs(mean-0.25) > 0.02 {
+		t.Errorf("mean = %.3f, want 0.25", mean)
+	}
+
+	// the same seed gives the same noise
+	again := make([]int, len(in))
+	q, _ = New(8, 2, TPDF, "", 1)
+	q.Quantize(in, again)
+	for i := range out {
+		if out[i] != again[i] {
+			t.Fatalf("sample %d differs between runs", i)
+		}
+	}
+}
+
+func TestShaping(t *testing.T) {
+	// First order shaping moves the error to high frequencies, so the
+	// error of neighbouring samples largely cancels out.
+	in := make([]float64, 44100)
+	for i := range in {
+		in[i] = 0.3 * math.Sin(2*math.Pi*440*float64(i)/44100)
+	}
+	lowNoise := func(shaping string) float64 {
+		q, err := New(8, 1, TPDF, shaping, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]int, len(in))
+		q.Quantize(in, out)
+		// a 16 tap moving sum keeps only the low end of the error
+		sum := 0.0
+		for i := 16; i < len(in); i++ {
+			e := 0.0
+			for k := i - 16; k < i; k++ {
+				e += float64(out[k]) - in[k]*128
+			}
+			sum += e * e
+		}
+		return sum
+	}
+	if plain, shaped := lowNoise("none"), lowNoise("simple"); shaped > plain/2 {
+		t.Errorf("low frequency noise %v with shaping, %v without", shaped, plain)
+	}
+	for _, s := range []string{"lipshitz", "fweighted"} {
+		if _, err := New(16, 1, TPDF, s, 0); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := New(16, 1, "rpdf", "", 0); err == nil {
+		t.Error("unknown dither: no error")
+	}
+	if _, err := New(16, 1, TPDF, "loud", 0); err == nil {
+		t.Error("unknown shaping: no error")
+	}
+}
@@ -0,0 +1,238 @@
+package loudness
+
+import (
+	"math"
+	"sort"
+	"soxy/biquad"
+)
+
+const (
+	// absoluteGate is the level below which blocks are ignored (LUFS).
+	absoluteGate = -70.0
+	// integratedGate is the relative gate for integrated loudness (LU).
+	integratedGate = -10.0
+	// rangeGate is the relative gate for loudness range (LU).
+	rangeGate = -20.0
+)
+
+// Meter measures loudness according to ITU-R BS.1770-4 and EBU R128:
+// integrated loudness, loudness range, sample peak and true peak.  Audio
+// is written as interleaved floats where 1.0 is full scale.  Only the
+// energy of every 100 ms step is kept, so memory grows by a few bytes per
+// second of audio.
+type Meter struct {
+	SampleRate  float64
+	NumChannels int
+
+	shelf    biquad.Bank
+	highPass biquad.Bank
+	weights  []float64
+
+	stepSize int
+	stepPos  int
+	stepSum  []float64
+	// steps holds the weighted mean square of every complete 100 ms step.
+	steps []float64
+
+	peaks *PeakMeter
+}
+
+// NewMeter returns a meter for audio at sampleRate with numChannels
+// interleaved channels.
+func NewMeter(sampleRate float64, numChannels int) *Meter {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	shelf, highPass := kWeighting(sampleRate)
+	return &Meter{
+		SampleRate:  sampleRate,
+		NumChannels: numChannels,
+		shelf:       biquad.NewBank(shelf, numChannels),
+		highPass:    biquad.NewBank(highPass, numChannels),
+		weights:     channelWeights(numChannels),
+		stepSize:    int(math.Round(sampleRate / 10)),
+		stepSum:     make([]float64, numChannels),
+		peaks:       NewPeakMeter(sampleRate, numChannels),
+	}
+}
+
+// kWeighting returns the two stages of the K-weighting filter: a high
+// shelf modelling the head and an RLB high pass.  The coefficients are
+// derived for any sample rate from the analog prototypes, matching the
+// tables in BS.1770 at 48 kHz.
+func kWeighting(sampleRate float64) (biquad.BiQuad, biquad.BiQuad) {
+	var shelf, highPass biquad.BiQuad
+
+	f0 := 1681.974450955533
+	G := 3.999843853973347
+	Q := 0.7071752369554196
+	K := math.Tan(math.Pi * f0 / sampleRate)
+	Vh := math.Pow(10, G/20)
+	Vb := math.Pow(Vh, 0.4996667741545416)
+	a0 := 1 + K/Q + K*K
+	shelf.A0 = (Vh + Vb*K/Q + K*K) / a0
+	shelf.A1 = 2 * (K*K - Vh) / a0
+	shelf.A2 = (Vh - Vb*K/Q + K*K) / a0
+	shelf.B1 = 2 * (K*K - 1) / a0
+	shelf.B2 = (1 - K/Q + K*K) / a0
+	shelf.C0 = 1.0
+
+	f0 = 38.13547087602444
+	Q = 0.5003270373238773
+	K = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + K/Q + K*K
+	highPass.A0 = 1.0
+	highPass.A1 = -2.0
+	highPass.A2 = 1.0
+	highPass.B1 = 2 * (K*K - 1) / a0
+	highPass.B2 = (1 - K/Q + K*K) / a0
+	highPass.C0 = 1.0
+	return shelf, highPass
+}
+
+// channelWeights follows BS.1770: surround channels of a 5.1 layout count
+// +1.5 dB and the LFE channel is ignored.
+func channelWeights(numChannels int) []float64 {
+	weights := make([]float64, numChannels)
+	for ch := range weights {
+		weights[ch] = 1.0
+	}
+	if numChannels == 6 {
+		weights[3] = 0.0
+		weights[4] = 1.41
+		weights[5] = 1.41
+	}
+	return weights
+}
+
+// Write measures a block of interleaved samples.
+func (m *Meter) Write(data []float64) {
+	m.peaks.Write(data)
+	for i := 0; i+m.NumChannels <= len(data); i += m.NumChannels {
+		for ch := 0; ch < m.NumChannels; ch++ {
+			x := data[i+ch]
+			y := m.highPass[ch].DoBiQuad(m.shelf[ch].DoBiQuad(x))
+			m.stepSum[ch] += y * y
+		}
+		m.stepPos++
+		if m.stepPos == m.stepSize {
+			power := 0.0
+			for ch, sum := range m.stepSum {
+				power += m.weights[ch] * sum / float64(m.stepSize)
+				m.stepSum[ch] = 0
+			}
+			m.steps = append(m.steps, power)
+			m.stepPos = 0
+		}
+	}
+}
+
+// blocks returns the mean power of every window of size steps, advancing
+// one step at a time.
+func (m *Meter) blocks(size int) []float64 {
+	if len(m.steps) < size {
+		return nil
+	}
+	blocks := make([]float64, 0, len(m.steps)-size+1)
+	sum := 0.0
+	for i, p := range m.steps {
+		sum += p
+		if i >= size {
+			sum -= m.steps[i-size]
+		}
+		if i >= size-1 {
+			blocks = append(blocks, sum/float64(size))
+		}
+	}
+	return blocks
+}
+
+func toLUFS(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gated returns the blocks above the absolute gate and the relative gate
+// derived from them.
+func gated(blocks []float64, relative float64) []float64 {
+	var above []float64
+	sum := 0.0
+	for _, p := range blocks {
+		if toLUFS(p) > absoluteGate {
+			above = append(above, p)
+			sum += p
+		}
+	}
+	if len(above) == 0 {
+		return nil
+	}
+	gate := toLUFS(sum/float64(len(above))) + relative
+	var kept []float64
+	for _, p := range above {
+		if toLUFS(p) > gate {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// Integrated returns the gated integrated loudness in LUFS using 400 ms
+// blocks with 75% overlap.  Silence returns -Inf.
+func (m *Meter) Integrated() float64 {
+	kept := gated(m.blocks(4), integratedGate)
+	if len(kept) == 0 {
+		return math.Inf(-1)
+	}
+	sum := 0.0
+	for _, p := range kept {
+		sum += p
+	}
+	return toLUFS(sum / float64(len(kept)))
+}
+
+// Range returns the loudness range in LU following EBU Tech 3342: the
+// spread between the 10th and 95th percentile of the gated 3 s short term
+// loudness.
+func (m *Meter) Range() float64 {
+	kept := gated(m.blocks(30), rangeGate)
+	if len(kept) == 0 {
+		return 0
+	}
+	loudness := make([]float64, len(kept))
+	for i, p := range kept {
+		loudness[i] = toLUFS(p)
+	}
+	sort.Float64s(loudness)
+	return percentile(loudness, 0.95) - percentile(loudness, 0.10)
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// SamplePeak returns the largest absolute sample in dBFS.
+func (m *Meter) SamplePeak() float64 {
+	return m.peaks.SamplePeak()
+}
+
+// TruePeak returns the largest absolute inter-sample peak in dBTP.
+func (m *Meter) TruePeak() float64 {
+	return m.peaks.TruePeak()
+}
+
+// NormGain returns the gain in dB that moves the integrated loudness to
+// integrated without pushing the true peak above truePeak.  limited is set
+// when the peak constraint reduced the gain.
+func (m *Meter) NormGain(integrated, truePeak float64) (gain float64, limited bool) {
+	current := m.Integrated()
+	if math.IsInf(current, -1) {
+		return 0, false
+	}
+	gain = integrated - current
+	if headroom := truePeak - m.TruePeak(); gain > headroom {
+		return headroom, true
+	}
+	return gain, false
+}
@@ -0,0 +1,78 @@
+package loudness
+
+import (
+	"math"
+	"testing"
+)
+
+// sine returns seconds of an interleaved sine at freq with the given peak
+// level in dBFS on every channel.
+func sine(sampleRate, freq, dbfs, seconds float64, numChannels int) []float64 {
+	amp := math.Pow(10, dbfs/20)
+	n := int(sampleRate * seconds)
+	data := make([]float64, 0, n*numChannels)
+	for i := 0; i < n; i++ {
+		v := amp * math.Sin(2*math.Pi*freq*float64(i)/sampleRate)
+		for ch := 0; ch < numChannels; ch++ {
+			data = append(data, v)
+		}
+	}
+	return data
+}
+
+func TestIntegrated(t *testing.T) {
+	// EBU Tech 3341 case 1: a stereo 1 kHz sine at -23 dBFS reads -23 LUFS.
+	for _, rate := range []float64{44100, 48000, 192000} {
+		m := NewMeter(rate, 2)
+		m.Write(sine(rate, 1000, -23, 20, 2))
+		if got := m.Integrated(); math.Abs(got+23) > 0.1 {
+			t.Errorf("%v Hz: integrated = %.2f LUFS, want -23", rate, got)
+		}
+		if got := m.Range(); got > 0.1 {
+			t.Errorf("%v Hz: range = %.2f LU, want 0", rate, got)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	// EBU Tech 3342 case 1: 20 s at -20 dBFS then 20 s at -30 dBFS is 10 LU.
+	m := NewMeter(48000, 2)
+	m.Write(sine(48000, 1000, -20, 20, 2))
+	m.Write(sine(48000, 1000, -30, 20, 2))
+	if got := m.Range(); math.Abs(got-10) > 0.1 {
+		t.Errorf("range = %.2f LU, want 10", got)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	m := NewMeter(48000, 1)
+	m.Write(make([]float64, 48000))
+	if got := m.Integrated(); !math.IsInf(got, -1) {
+		t.Errorf("integrated = %v, want -Inf", got)
+	}
+	if gain, _ := m.NormGain(-23, -1); gain != 0 {
+		t.Errorf("gain = %v, want 0", gain)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// A sine at fs/4 sampled 45 degrees off its peaks reads 3 dB low on
+	// sample peak but not on true peak.
+	rate := 48000.0
+	data := make([]float64, 48000)
+	for i := range data {
+		data[i] = 0.5 * math.Sin(math.Pi/2*float64(i)+math.Pi/4)
+	}
+	m := NewMeter(rate, 1)
+	m.Write(data)
+	if got := m.SamplePeak(); math.Abs(got+9.03) > 0.1 {
+		t.Errorf("sample peak = %.2f dBFS, want -9.03", got)
+	}
+	if got := m.TruePeak(); math.Abs(got+6.02) > 0.3 {
+		t.Errorf("true peak = %.2f dBTP, want -6.02", got)
+	}
+	gain, limited := m.NormGain(0, -1)
+	if !limited || math.Abs(gain-(-1-m.TruePeak())) > 1e-9 {
+		t.Errorf("gain = %v limited = %v, want peak limited gain", gain, limited)
+	}
+}
@@ -0,0 +1,125 @@
+package loudness
+
+import "math"
+
+// PeakMeter tracks the sample peak and the true peak of interleaved audio
+// where 1.0 is full scale.
+type PeakMeter struct {
+	samplePeak float64
+	truePeak   *truePeak
+}
+
+// NewPeakMeter returns a peak meter for audio at sampleRate with
+// numChannels interleaved channels.
+func NewPeakMeter(sampleRate float64, numChannels int) *PeakMeter {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	return &PeakMeter{truePeak: newTruePeak(sampleRate, numChannels)}
+}
+
+// Write measures a block of interleaved samples.
+func (p *PeakMeter) Write(data []float64) {
+	p.truePeak.write(data)
+	for _, x := range data {
+		if a := math.Abs(x); a > p.samplePeak {
+			p.samplePeak = a
+		}
+	}
+}
+
+// SamplePeak returns the largest absolute sample in dBFS.
+func (p *PeakMeter) SamplePeak() float64 {
+	return 20 * math.Log10(p.samplePeak)
+}
+
+// TruePeak returns the largest absolute inter-sample peak in dBTP.  It is
+// never below the sample peak.
+func (p *PeakMeter) TruePeak() float64 {
+	return 20 * math.Log10(math.Max(p.truePeak.peak, p.samplePeak))
+}
+
+// tapsPerPhase is the length of each polyphase branch of the interpolator.
+const tapsPerPhase = 12
+
+// truePeak estimates inter-sample peaks by oversampling with a windowed sinc
+// interpolator as described in BS.1770-4 annex 2.  Rates of 48 kHz and
+// below are oversampled 4x, 96 kHz 2x and anything faster is left alone.
+type truePeak struct {
+	numChannels int
+	phases      [][]float64
+	history     [][]float64
+	pos         int
+	peak        float64
+}
+
+func newTruePeak(sampleRate float64, numChannels int) *truePeak {
+	factor := 4
+	if sampleRate >= 176400 {
+		factor = 1
+	} else if sampleRate >= 88200 {
+		factor = 2
+	}
+	t := &truePeak{numChannels: numChannels}
+	if factor == 1 {
+		return t
+	}
+
+	n := factor * tapsPerPhase
+	center := float64(n-1) / 2
+	t.phases = make([][]float64, factor)
+	for p := range t.phases {
+		t.phases[p] = make([]float64, tapsPerPhase)
+		sum := 0.0
+		for k := range t.phases[p] {
+			i := p + k*factor
+			x := (float64(i) - center) / float64(factor)
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			// Blackman window
+			w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+			t.phases[p][k] = sinc * w
+			sum += t.phases[p][k]
+		}
+		for k := range t.phases[p] {
+			t.phases[p][k] /= sum
+		}
+	}
+	t.history = make([][]float64, numChannels)
+	for ch := range t.history {
+		t.history[ch] = make([]float64, tapsPerPhase)
+	}
+	return t
+}
+
+func (t *truePeak) write(data []float64) {
+	if t.phases == nil {
+		return
+	}
+	for i := 0; i+t.numChannels <= len(data); i += t.numChannels {
+		for ch := 0; ch < t.numChannels; ch++ {
+			hist := t.history[ch]
+			hist[t.pos] = data[i+ch]
+			for _, phase := range t.phases {
+				y := 0.0
+				idx := t.pos
+				for _, h := range phase {
+					y += h * hist[idx]
+					idx--
+					if idx < 0 {
+						idx = tapsPerPhase - 1
+					}
+				}
+				if a := math.Abs(y); a > t.peak {
+					t.peak = a
+				}
+			}
+		}
+		t.pos++
+		if t.pos == tapsPerPhase {
+			t.pos = 0
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"soxy/biquad/bsf"
+	"soxy/biquad/hpf"
+	"soxy/biquad/lpf"
+	"soxy/biquad/parametric"
+	"soxy/compressor"
+	"soxy/processor"
+	"strings"
+)
+
+// ChainEntry is a single [[chain]] table.  Type selects the processor and
+// the remaining keys are the settings for that processor - keys that do not
+// apply to the selected type are ignored.
+type ChainEntry struct {
+	Type string
+
+	// hpf, lpf, parametric and bsf
+	Freq float64
+	Gain float64
+	Q    float64
+
+	// compressor
+	InputGain      float64
+	OutputGain     float64
+	Threshold      float64
+	AttackTime     float64
+	ReleaseTime    float64
+	Ratio          float64
+	Knee           float64
+	LookAheadDelay float64
+	StereoLink     int
+	Analog         bool
+	// Accepted so a [compressor] table can be pasted as is.
+	ProcessorType int
+	SampleRate    float64
+}
+
+// processor builds a fresh processor from the entry.
+func (e ChainEntry) processor() (processor.Processor, error) {
+	switch strings.ToLower(e.Type) {
+	case "hpf":
+		return &hpf.HPF{Freq: e.Freq}, nil
+	case "lpf":
+		return &lpf.LPF{Freq: e.Freq}, nil
+	case "parametric":
+		return &parametric.Parametric{Freq: e.Freq, Gain: e.Gain, Q: e.Q}, nil
+	case "bsf":
+		return &bsf.BSF{Freq: e.Freq, Q: e.Q}, nil
+	case "compressor":
+		return &compressor.Compressor{
+			InputGain:      e.InputGain,
+			OutputGain:     e.OutputGain,
+			Threshold:      e.Threshold,
+			AttackTime:     e.AttackTime,
+			ReleaseTime:    e.ReleaseTime,
+			Ratio:          e.Ratio,
+			Knee:           e.Knee,
+			LookAheadDelay: e.LookAheadDelay,
+			StereoLink:     e.StereoLink,
+			ProcessorType:  e.ProcessorType,
+			SampleRate:     e.SampleRate,
+			Analog:         e.Analog,
+		}, nil
+	case "":
+		return nil, errors.New("missing type")
+	}
+	return nil, fmt.Errorf("unknown type %q (want hpf, lpf, parametric, bsf or compressor)", e.Type)
+}
+
+// chain builds the processors described by the config.  Every call returns
+// new processors so concurrent jobs never share filter state.  Without a
+// [[chain]] section the historical HPF -> LPF -> Parametric -> Compressor
+// order is used.
+func (c Config) chain() (processor.Chain, error) {
+	var chain processor.Chain
+	if len(c.Chain) != 0 {
+		if c.HPF != nil || c.LPF != nil || len(c.Parametric) != 0 || c.Compressor != nil {
+			return nil, errors.New("config: use either [[chain]] or the [hpf], [lpf], [[parametric]] and [compressor] sections, not both")
+		}
+		for idx, e := range c.Chain {
+			p, err := e.processor()
+			if err != nil {
+				return nil, fmt.Errorf("config: chain[%d]: %v", idx, err)
+			}
+			chain = append(chain, p)
+		}
+		return chain, nil
+	}
+
+	if c.HPF != nil {
+		f := *c.HPF
+		chain = append(chain, &f)
+	}
+	if c.LPF != nil {
+		f := *c.LPF
+		chain = append(chain, &f)
+	}
+	for _, eq := range c.Parametric {
+		f := *eq
+		chain = append(chain, &f)
+	}
+	if c.Compressor != nil {
+		comp := *c.Compressor
+		chain = append(chain, &comp)
+	}
+	return chain, nil
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"soxy/biquad/hpf"
+	"soxy/biquad/lpf"
+	"soxy/biquad/parametric"
+	"soxy/compressor"
+
+	"github.com/naoina/toml"
+)
+
+// Config is the decoded form of a soxy TOML config.
+type Config struct {
+	Master struct {
+		Gain              float64
+		BitDepth          float64
+		SampleRate        int
+		Bandwidth         float64
+		RippleFactor      float64
+		RippleAttenuation float64
+		Tolerance         float64
+		// InternalRate is the rate the chain runs at: a number, "native"
+		// for the rate of each input, or empty for 192000.
+		InternalRate string
+		// Oversampling runs the chain at this multiple of the input rate
+		// instead of InternalRate.
+		Oversampling int
+		Normalize    bool
+		// Float writes IEEE float output instead of integers.
+		Float        bool
+		Dither       string
+		NoiseShaping string
+		DitherSeed   int64
+
+		IntegratedLoudness string
+		LoudnessRange      string
+		TruePeak           string
+		PeakNorm           string
+		PeakMode           string
+
+		SoxNorm   bool
+		SoxNormTo string
+	}
+	Compressor *compressor.Compressor
+	Parametric []*parametric.Parametric
+	HPF        *hpf.HPF
+	LPF        *lpf.LPF
+	// Chain lists processors in the order they run.  When present it
+	// replaces the HPF, LPF, Parametric and Compressor sections.
+	Chain []ChainEntry
+}
+
+// LoadConfig decodes the config at path, including the configs it
+// extends.  It doesn't validate the result.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	t, err := loadConfigTable(path, nil)
+	if err != nil {
+		return c, err
+	}
+	err = toml.UnmarshalTable(t, &c)
+	return c, err
+}
+
+// ParseConfig decodes a config held in memory.  data is TOML, or JSON
+// when it starts with "{", using the same keys.  The configs a TOML config
+// extends are found relative to dir.  It doesn't validate the result.
+func ParseConfig(data []byte, dir string) (Config, error) {
+	return parseConfig(data, dir, false)
+}
+
+// ParseConfigWithin is ParseConfig for configs from outside, such as the
+// ones sent to soxy serve: the configs it extends must be named by paths
+// below dir, not absolute ones or ones going up with "..".
+func ParseConfigWithin(data []byte, dir string) (Config, error) {
+	return parseConfig(data, dir, true)
+}
+
+func parseConfig(data []byte, dir string, within bool) (Config, error) {
+	var c Config
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return c, fmt.Errorf("config: %v", err)
+		}
+		return c, nil
+	}
+	t, err := parseConfigTable(data, "config", dir, within, nil)
+	if err != nil {
+		return c, err
+	}
+	err = toml.UnmarshalTable(t, &c)
+	return c, err
+}
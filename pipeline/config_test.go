@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base := "[master]\ngain=0.5\nbitdepth=24.0\nsamplerate=48000\n[hpf]\nfreq=40.0\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.toml"), []byte(base), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"toml", "[master]\ngain=0.8\nbitdepth=24.0\nsamplerate=48000\n[hpf]\nfreq=40.0\n"},
+		{"extends", "extends=\"base.toml\"\n[master]\ngain=0.8\n"},
+		{"json", ` {"master": {"gain": 0.8, "bitdepth": 24, "samplerate": 48000}, "hpf": {"freq": 40}}`},
+	}
+	for _, tt := range tests {
+		c, err := ParseConfig([]byte(tt.data), dir)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if c.Master.Gain != 0.8 || c.Master.SampleRate != 48000 || c.HPF == nil || c.HPF.Freq != 40 {
+			t.Errorf("%s: decoded %+v", tt.name, c)
+		}
+	}
+
+	for _, bad := range []string{"[master]\nnope=1\n", `{"master": {"nope": 1}}`, "extends=\"missing.toml\"\n"} {
+		if _, err := ParseConfig([]byte(bad), dir); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestParseConfigWithin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	presets := filepath.Join(dir, "presets")
+	files := map[string]string{
+		"other.toml":              "[master]\ngain=0.5\nbitdepth=24.0\nsamplerate=48000\n",
+		"presets/base.toml":       "[master]\ngain=0.5\nbitdepth=24.0\nsamplerate=48000\n",
+		"presets/voice/up.toml":   "extends=\"../base.toml\"\n",
+		"presets/voice/away.toml": "extends=\"../../other.toml\"\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		extends string
+		ok      bool
+	}{
+		{`"base.toml"`, true},
+		// the presets themselves are trusted
+		{`"voice/up.toml"`, true},
+		{`"voice/away.toml"`, true},
+		{`"../other.toml"`, false},
+		{`"voice/../../other.toml"`, false},
+		{`["base.toml", "../other.toml"]`, false},
+		{`"` + filepath.ToSlash(filepath.Join(presets, "base.toml")) + `"`, false},
+	}
+	for _, tt := range tests {
+		data := []byte("extends=" + tt.extends + "\n[master]\ngain=0.8\n")
+		c, err := ParseConfigWithin(data, presets)
+		if tt.ok != (err == nil) {
+			t.Errorf("extends=%s: got %v", tt.extends, err)
+			continue
+		}
+		if err == nil && (c.Master.Gain != 0.8 || c.Master.SampleRate != 48000) {
+			t.Errorf("extends=%s: decoded %+v", tt.extends, c)
+		}
+		if _, err := ParseConfig(data, presets); err != nil {
+			t.Errorf("extends=%s: ParseConfig: %v", tt.extends, err)
+		}
+	}
+}
+
+func TestValidateCompressorGain(t *testing.T) {
+	// the [[chain]] example of the README, which leaves the gains at 0 dB
+	chain := "[master]\ngain=1.0\nbitdepth=24.0\nsamplerate=48000\n[[chain]]\ntype=\"compressor\"\nthreshold=-12.0\nattacktime=0.1\nreleasetime=150.0\nratio=2.0\nknee=10.0\n"
+	tests := []struct {
+		gains string
+		ok    bool
+	}{
+		{"", true},
+		{"inputgain=-6.0\noutputgain=3.0\nsamplerate=16000.0\n", true},
+		{"inputgain=61.0\n", false},
+		{"outputgain=-80.0\n", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseConfig([]byte(chain+tt.gains), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%q: got %v", tt.gains, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/naoina/toml"
+	"github.com/naoina/toml/ast"
+)
+
+// extendsKey names the configs a config is based on.  It takes a path or
+// an array of paths, relative to the config that names them.
+const extendsKey = "extends"
+
+// loadConfigTable parses the config at path and merges it over the
+// configs it extends.  seen holds the files already being loaded so a
+// loop is reported instead of recursing forever.
+func loadConfigTable(path string, seen []string) (*ast.Table, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range seen {
+		if s == abs {
+			return nil, fmt.Errorf("config: %s extends itself", path)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigTable(data, path, filepath.Dir(path), false, append(seen, abs))
+}
+
+// parseConfigTable parses data, named name in errors, and merges it over
+// the configs it extends, which are found relative to dir.  With within
+// set they must be below dir; the configs they extend in turn are trusted.
+func parseConfigTable(data []byte, name, dir string, within bool, seen []string) (*ast.Table, error) {
+	t, err := toml.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	kv, ok := takeField(t, extendsKey).(*ast.KeyValue)
+	if !ok {
+		return t, nil
+	}
+
+	var bases []string
+	switch v := kv.Value.(type) {
+	case *ast.String:
+		bases = append(bases, v.Value)
+	case *ast.Array:
+		for _, e := range v.Value {
+			s, ok := e.(*ast.String)
+			if !ok {
+				return nil, fmt.Errorf("%s: extends must be a path or an array of paths", name)
+			}
+			bases = append(bases, s.Value)
+		}
+	default:
+		return nil, fmt.Errorf("%s: extends must be a path or an array of paths", name)
+	}
+
+	var merged *ast.Table
+	for _, b := range bases {
+		if within && (filepath.IsAbs(b) || strings.Contains(b, "..")) {
+			return nil, fmt.Errorf("%s: extends must name a config below %s, not %q", name, dir, b)
+		}
+		if !filepath.IsAbs(b) {
+			b = filepath.Join(dir, b)
+		}
+		base, err := loadConfigTable(b, seen)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = base
+		} else {
+			mergeTable(merged, base)
+		}
+	}
+	if merged == nil {
+		return t, nil
+	}
+	mergeTable(merged, t)
+	return merged, nil
+}
+
+// normKey matches keys the way the TOML decoder matches them to fields.
+func normKey(key string) string {
+	return strings.Replace(strings.ToLower(key), "_", "", -1)
+}
+
+// takeField removes key from t and returns its value, or nil.
+func takeField(t *ast.Table, key string) interface{} {
+	for k, v := range t.Fields {
+		if normKey(k) == normKey(key) {
+			delete(t.Fields, k)
+			return v
+		}
+	}
+	return nil
+}
+
+// mergeTable merges src into dst.  Tables are merged key by key and arrays
+// of tables element by element, so src only needs to hold what it
+// changes; any other value in src replaces the one in dst.
+func mergeTable(dst, src *ast.Table) {
+	for key, sv := range src.Fields {
+		dk := key
+		for k := range dst.Fields {
+			if normKey(k) == normKey(key) {
+				dk = k
+				break
+			}
+		}
+		dv := dst.Fields[dk]
+		switch s := sv.(type) {
+		case *ast.Table:
+			if d, ok := dv.(*ast.Table); ok {
+				mergeTable(d, s)
+				continue
+			}
+		case []*ast.Table:
+			if d, ok := dv.([]*ast.Table); ok {
+				for i, t := range s {
+					if i < len(d) {
+						mergeTable(d[i], t)
+					} else {
+						d = append(d, t)
+					}
+				}
+				dst.Fields[dk] = d
+				continue
+			}
+		}
+		dst.Fields[dk] = sv
+	}
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"strings"
+
+	"soxy/resample/polyphase"
+)
+
+// compressorDelay is the length in seconds of the lookahead delay line a
+// compressor keeps per channel.
+const compressorDelay = 0.3
+
+// Footprint estimates the memory in bytes a file of frames frames with
+// numChans channels at rate takes to process.  Streaming configs hold a
+// block per stage, the resampler filters and their history and the chain
+// state such as compressor delay lines, whatever the length of the file.
+// Normalize and peaknorm also hold the whole file as 64 bit floats at the
+// output rate until the gain is known, in a temp file that may live in
+// memory, and the loudness history.  It is an estimate for scheduling,
+// not a measurement.
+func (c Config) Footprint(frames int64, numChans, rate int) (int64, error) {
+	ir, err := internalRate(c, rate)
+	if err != nil {
+		return 0, err
+	}
+	if frames < 0 || rate <= 0 || numChans <= 0 {
+		return 0, nil
+	}
+	chans := int64(numChans)
+	in := int64(BlockSize) * chans
+	up := in * int64(ir) / int64(rate)
+	out := in * int64(c.Master.SampleRate) / int64(rate)
+	// the decoded block, its float and full scale copies, the internal
+	// rate block split by channel and the output block split and joined
+	floats := 3*in + 2*up + 2*out
+	for _, r := range [][2]int{{rate, ir}, {ir, c.Master.SampleRate}} {
+		if r[0] == r[1] {
+			continue
+		}
+		coefs, taps, err := polyphase.Size(r[0], r[1], c.filterParams())
+		if err != nil {
+			return 0, err
+		}
+		floats += int64(coefs) + int64(taps)*chans
+	}
+	floats += int64(c.compressors()) * int64(compressorDelay*float64(ir)) * chans
+	if c.Master.Normalize || c.Master.PeakNorm != "" {
+		floats += frames * int64(c.Master.SampleRate) / int64(rate) * chans
+	}
+	if c.Master.Normalize {
+		// one loudness block every 100 ms
+		floats += frames*10/int64(rate) + 1
+	}
+	return floats * 8, nil
+}
+
+// compressors returns the number of compressors in the chain.
+func (c Config) compressors() int {
+	if len(c.Chain) == 0 {
+		if c.Compressor != nil {
+			return 1
+		}
+		return 0
+	}
+	n := 0
+	for _, e := range c.Chain {
+		if strings.ToLower(e.Type) == "compressor" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"soxy/loudness"
+	"strconv"
+	"strings"
+)
+
+// Defaults used by ffmpeg's loudnorm when a target is left out.
+const (
+	defaultIntegratedLoudness = -24.0
+	defaultLoudnessRange      = 7.0
+	defaultTruePeak           = -2.0
+)
+
+// parseTarget reads one of the string loudness keys of [master].
+func parseTarget(key, value string, def float64) (float64, error) {
+	if value == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("master.%s: %q is not a number", key, value)
+	}
+	return v, nil
+}
+
+// loudnormGain returns the gain in dB that moves the measured audio to
+// integratedloudness while keeping the true peak under truepeak.  The gain
+// is static, so loudnessrange can't be enforced - when the measured range
+// is wider than the target a note says so instead.
+func loudnormGain(c Config, m *loudness.Meter) (float64, []string, error) {
+	integrated, err := parseTarget("integratedloudness", c.Master.IntegratedLoudness, defaultIntegratedLoudness)
+	if err != nil {
+		return 0, nil, err
+	}
+	lra, err := parseTarget("loudnessrange", c.Master.LoudnessRange, defaultLoudnessRange)
+	if err != nil {
+		return 0, nil, err
+	}
+	truePeak, err := parseTarget("truepeak", c.Master.TruePeak, defaultTruePeak)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var notes []string
+	gain, limited := m.NormGain(integrated, truePeak)
+	if limited {
+		notes = append(notes, fmt.Sprintf("loudness gain limited to %.2f dB by the %.1f dBTP true peak target", gain, truePeak))
+	}
+	if r := m.Range(); r > lra {
+		notes = append(notes, fmt.Sprintf("loudness range %.1f LU is wider than the %.1f LU target", r, lra))
+	}
+	return gain, notes, nil
+}
+
+// peakNormGain returns the gain in dB that brings the peak measured by m,
+// raised by the gain already applied, to the [master] peaknorm level in
+// dBFS.  peakmode picks sample peaks ("sample", the default and what sox
+// --norm did) or true peaks ("true").
+func peakNormGain(c Config, m *loudness.PeakMeter, applied float64) (float64, error) {
+	target, err := strconv.ParseFloat(c.Master.PeakNorm, 64)
+	if err != nil {
+		return 0, fmt.Errorf("master.peaknorm: %q is not a number", c.Master.PeakNorm)
+	}
+	mode := strings.ToLower(c.Master.PeakMode)
+	if mode != "" && mode != "sample" && mode != "true" {
+		return 0, fmt.Errorf("master.peakmode: %q is not sample or true", c.Master.PeakMode)
+	}
+
+	peak := m.SamplePeak()
+	if mode == "true" {
+		peak = m.TruePeak()
+	}
+	if math.IsInf(peak, -1) {
+		// nothing to normalize in digital silence
+		return 0, nil
+	}
+	return target - (peak + applied), nil
+}
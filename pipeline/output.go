@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"soxy/dither"
+	"soxy/wavio"
+)
+
+// encoder requantizes the processed audio to the [master] output format
+// and writes it.  Integer output goes through a dither.Quantizer, float
+// output is written as is.
+type encoder struct {
+	w    *wavio.Writer
+	q    *dither.Quantizer
+	ints []int
+}
+
+// newEncoder writes the header of the output file.  bitdepth is 8, 16, 24
+// or 32 for integer output, or 32 or 64 with float=true.  dither defaults
+// to "tpdf" and noiseshaping to "none"; ditherseed makes the noise
+// repeatable.
+func newEncoder(c Config, out io.WriteSeeker, numChans int) (*encoder, error) {
+	bits := int(c.Master.BitDepth)
+	if float64(bits) != c.Master.BitDepth {
+		return nil, fmt.Errorf("master.bitdepth: %v is not a whole number", c.Master.BitDepth)
+	}
+	format := uint16(wavio.FormatPCM)
+	if c.Master.Float {
+		format = wavio.FormatIEEEFloat
+	}
+	// New has checked the layout, so this fails writing the header
+	w, err := wavio.NewWriter(out, c.Master.SampleRate, bits, numChans, format)
+	if err != nil {
+		return nil, &Error{Stage: StageWrite, Err: err}
+	}
+	enc := &encoder{w: w}
+	if c.Master.Float {
+		return enc, nil
+	}
+	kind := c.Master.Dither
+	if kind == "" {
+		kind = dither.TPDF
+	}
+	enc.q, err = dither.New(bits, numChans, kind, c.Master.NoiseShaping, c.Master.DitherSeed)
+	if err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// write adds interleaved samples where 1.0 is full scale.
+func (e *encoder) write(data []float64) error {
+	if e.q == nil {
+		return e.w.WriteFloats(data)
+	}
+	if cap(e.ints) < len(data) {
+		e.ints = make([]int, len(data))
+	}
+	ints := e.ints[:len(data)]
+	e.q.Quantize(data, ints)
+	return e.w.WriteInts(ints)
+}
+
+// Close finishes the header.
+func (e *encoder) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Clone returns a deep copy of the config, so changes to one copy never
+// show up in another.
+func (c Config) Clone() (Config, error) {
+	var cp Config
+	data, err := json.Marshal(c)
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(data, &cp)
+	return cp, err
+}
+
+// WithOverrides returns a copy of the config with the dotted keys, such as
+// "master.gain" or "parametric.1.freq", set to the values given.  Keys are
+// matched like the TOML decoder matches them, ignoring case; numeric parts
+// index arrays of tables such as [[parametric]], and one past the end adds
+// a table.
+func (c Config) WithOverrides(overrides map[string]string) (Config, error) {
+	cp, err := c.Clone()
+	if err != nil {
+		return cp, err
+	}
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := setKey(reflect.ValueOf(&cp).Elem(), strings.Split(k, "."), overrides[k]); err != nil {
+			return cp, fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return cp, nil
+}
+
+func setKey(v reflect.Value, path []string, value string) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if len(path) == 0 {
+		return setValue(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath == "" && strings.EqualFold(f.Name, path[0]) {
+				return setKey(v.Field(i), path[1:], value)
+			}
+		}
+		return fmt.Errorf("no key %q", path[0])
+	case reflect.Slice:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i > v.Len() {
+			return fmt.Errorf("%q is not an index from 0 to %d", path[0], v.Len())
+		}
+		if i == v.Len() {
+			// one past the end adds a table
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		}
+		return setKey(v.Index(i), path[1:], value)
+	}
+	return fmt.Errorf("%q has no keys below it", path[0])
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("a table can't be set to %q", value)
+	}
+	return nil
+}
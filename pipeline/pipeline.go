@@ -0,0 +1,347 @@
+// Package pipeline renders WAV audio the way the soxy command does: gain,
+// resampling to the internal rate, the processor chain, resampling to the
+// output rate, normalization and requantizing, all driven by a Config.
+//
+//	c, err := pipeline.LoadConfig("configs/voice/enus_uprez.toml")
+//	...
+//	p, err := pipeline.New(c)
+//	...
+//	report, err := p.Process(ctx, in, out, nil)
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"soxy/loudness"
+	"soxy/tempr"
+	"soxy/wavio"
+	"time"
+)
+
+// Stages reported in an Error or timed in a Report.  Resample and process
+// only appear in a Report: the resamplers and the chain don't fail.
+// Canceled only appears in an Error, for a file stopped because its
+// context was done.
+const (
+	StageConfig    = "config"
+	StageDecode    = "decode"
+	StageResample  = "resample"
+	StageProcess   = "process"
+	StageNormalize = "normalize"
+	StageWrite     = "write"
+	StageCanceled  = "canceled"
+)
+
+// Error records the stage processing failed in.  File is left empty by
+// Process for the caller to fill in.
+type Error struct {
+	File  string
+	Stage string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Stage, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Report describes what Process did to a file.
+type Report struct {
+	// InputRate and InternalRate are the rate of the input and the rate
+	// the chain ran at.
+	InputRate    int
+	InternalRate int
+	// LoudnessGain and PeakGain are the normalization gains applied in
+	// dB, 0 when the config doesn't ask for them.
+	LoudnessGain float64
+	PeakGain     float64
+	// Notes are warnings about the result, such as a loudness gain that
+	// was limited by the true peak target.
+	Notes []string
+	// Stages lists the stages Process went through, in the order they
+	// started, with the time spent in each.
+	Stages []StageTime
+}
+
+// StageTime is the time Process spent in one stage.
+type StageTime struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// timeStage adds d to the time of stage, appending it if it is new.
+func (r *Report) timeStage(stage string, d time.Duration) {
+	for i := range r.Stages {
+		if r.Stages[i].Stage == stage {
+			r.Stages[i].Duration += d
+			return
+		}
+	}
+	r.Stages = append(r.Stages, StageTime{Stage: stage, Duration: d})
+}
+
+// StageFunc is told as Process starts and finishes the stages of a file,
+// with the time spent in a stage once it finishes.  Decode, resample,
+// process and, without normalization, write take turns on each block in
+// a single pass over the file, so they all start before the first block
+// and finish after the last.  It is called on the goroutine running
+// Process.
+type StageFunc func(stage string, finished bool, took time.Duration)
+
+// stageClock times the stages of one file into its Report and tells the
+// StageFunc, which may be nil, as they start and finish.
+type stageClock struct {
+	report *Report
+	f      StageFunc
+	last   time.Time
+}
+
+// start starts stages and the next lap.
+func (s *stageClock) start(stages ...string) {
+	for _, st := range stages {
+		s.report.timeStage(st, 0)
+		if s.f != nil {
+			s.f(st, false, 0)
+		}
+	}
+	s.last = time.Now()
+}
+
+// lap adds the time since the last lap to stage.
+func (s *stageClock) lap(stage string) {
+	now := time.Now()
+	s.report.timeStage(stage, now.Sub(s.last))
+	s.last = now
+}
+
+// finish finishes stages.
+func (s *stageClock) finish(stages ...string) {
+	if s.f == nil {
+		return
+	}
+	for _, st := range stages {
+		for _, t := range s.report.Stages {
+			if t.Stage == st {
+				s.f(st, true, t.Duration)
+			}
+		}
+	}
+}
+
+// Pipeline processes audio with one config.  It holds no per-file state,
+// so Process can be called for many files at once.
+type Pipeline struct {
+	config Config
+}
+
+// New checks the config and returns a pipeline for it.  Errors list every
+// problem found, each starting with its config key.
+func New(c Config) (*Pipeline, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	cp, err := c.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{config: cp}, nil
+}
+
+// Config returns a copy of the config the pipeline was built with.
+func (p *Pipeline) Config() Config {
+	c, _ := p.config.Clone()
+	return c
+}
+
+// Process reads a WAV stream from r and writes the processed WAV to w.  r
+// is read front to back only, so it can be a pipe.  The WAV header can
+// only be finished by seeking back, so unless w is a seekable file at
+// offset 0 the output is built in a temp file and copied to w once it is
+// complete.  Errors are *Error values naming the stage that failed;
+// cancelling ctx stops processing between blocks.  stages, when not nil,
+// is told as each stage starts and finishes.
+func (p *Pipeline) Process(ctx context.Context, r io.Reader, w io.Writer, stages StageFunc) (Report, error) {
+	var report Report
+	clock := &stageClock{report: &report, f: stages}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		if pos, err := ws.Seek(0, io.SeekCurrent); err == nil && pos == 0 {
+			if err := p.render(ctx, r, ws, clock); err != nil {
+				return report, err
+			}
+			clock.finish(StageWrite)
+			return report, nil
+		}
+	}
+	tmp, err := tempr.TempFile("", "soxy", ".wav")
+	if err != nil {
+		return report, &Error{Stage: StageWrite, Err: err}
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := p.render(ctx, r, tmp, clock); err != nil {
+		return report, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return report, &Error{Stage: StageWrite, Err: err}
+	}
+	if _, err := io.Copy(w, tmp); err != nil {
+		return report, &Error{Stage: StageWrite, Err: err}
+	}
+	clock.lap(StageWrite)
+	clock.finish(StageWrite)
+	return report, nil
+}
+
+// render writes the processed file to out, timing it with clock.  The
+// write stage is left for Process to finish.
+func (p *Pipeline) render(ctx context.Context, in io.Reader, out io.WriteSeeker, clock *stageClock) error {
+	c := p.config
+	report := clock.report
+	fail := func(stage string, err error) error {
+		if _, ok := err.(*Error); ok {
+			return err
+		}
+		return &Error{Stage: stage, Err: err}
+	}
+	clock.start(StageDecode)
+	// wavio copes with the malformed headers found in most of the corpus
+	w, err := wavio.NewReader(in)
+	if err != nil {
+		return fail(StageDecode, err)
+	}
+	clock.lap(StageDecode)
+
+	chain, err := c.chain()
+	if err != nil {
+		return fail(StageConfig, err)
+	}
+	numChans := int(w.NumChans)
+	rate, err := internalRate(c, int(w.SampleRate))
+	if err != nil {
+		return fail(StageConfig, err)
+	}
+	report.InputRate, report.InternalRate = int(w.SampleRate), rate
+	if p := c.checkRate(rate); len(p) != 0 {
+		return fail(StageConfig, configError(p))
+	}
+	chain.Prepare(float64(rate), numChans)
+	// Resample to the internal rate for processing and back down to the
+	// target rate before requantizing.
+	up, err := newResampler(c, numChans, int(w.SampleRate), rate)
+	if err != nil {
+		return fail(StageConfig, err)
+	}
+	down, err := newResampler(c, numChans, rate, c.Master.SampleRate)
+	if err != nil {
+		return fail(StageConfig, err)
+	}
+
+	enc, err := newEncoder(c, out, numChans)
+	if err != nil {
+		return fail(StageConfig, err)
+	}
+	write := func(data []float64) error {
+		err := enc.write(data)
+		clock.lap(StageWrite)
+		if err != nil {
+			return &Error{Stage: StageWrite, Err: err}
+		}
+		return nil
+	}
+	if !c.Master.Normalize && c.Master.PeakNorm == "" {
+		clock.start(StageResample, StageProcess, StageWrite)
+		if err := processStream(ctx, c, chain, up, down, w, write, clock); err != nil {
+			return err
+		}
+		clock.finish(StageDecode, StageResample, StageProcess)
+		if err := enc.Close(); err != nil {
+			return fail(StageWrite, err)
+		}
+		clock.lap(StageWrite)
+		return nil
+	}
+
+	// Normalization needs the whole file measured before the gain is
+	// known, so hold it as 64 bit float until then.
+	tmp, err := tempr.TempFile("", "soxy", ".wav")
+	if err != nil {
+		return fail(StageWrite, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	tw, err := wavio.NewWriter(tmp, c.Master.SampleRate, 64, numChans, wavio.FormatIEEEFloat)
+	if err != nil {
+		return fail(StageWrite, err)
+	}
+	var meter *loudness.Meter
+	if c.Master.Normalize {
+		meter = loudness.NewMeter(float64(c.Master.SampleRate), numChans)
+	}
+	var peaks *loudness.PeakMeter
+	if c.Master.PeakNorm != "" {
+		peaks = loudness.NewPeakMeter(float64(c.Master.SampleRate), numChans)
+	}
+	// measuring the file as it goes by is part of normalizing it
+	sink := func(data []float64) error {
+		if meter != nil {
+			meter.Write(data)
+		}
+		if peaks != nil {
+			peaks.Write(data)
+		}
+		err := tw.WriteFloats(data)
+		clock.lap(StageNormalize)
+		if err != nil {
+			return &Error{Stage: StageWrite, Err: err}
+		}
+		return nil
+	}
+	clock.start(StageResample, StageProcess, StageNormalize)
+	if err := processStream(ctx, c, chain, up, down, w, sink, clock); err != nil {
+		return err
+	}
+	clock.finish(StageDecode, StageResample, StageProcess)
+	if err := tw.Close(); err != nil {
+		return fail(StageWrite, err)
+	}
+
+	if c.Master.Normalize {
+		// Loudness normalization first
+		gain, notes, err := loudnormGain(c, meter)
+		if err != nil {
+			return fail(StageNormalize, err)
+		}
+		report.LoudnessGain = gain
+		report.Notes = append(report.Notes, notes...)
+	}
+	if c.Master.PeakNorm != "" {
+		// Peak normalization last so the peak of the final file is exact
+		gain, err := peakNormGain(c, peaks, report.LoudnessGain)
+		if err != nil {
+			return fail(StageNormalize, err)
+		}
+		report.PeakGain = gain
+	}
+	clock.lap(StageNormalize)
+	clock.finish(StageNormalize)
+	clock.start(StageWrite)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(StageWrite, err)
+	}
+	if err := applyGain(ctx, tmp, write, report.LoudnessGain+report.PeakGain); err != nil {
+		return fail(StageWrite, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fail(StageWrite, err)
+	}
+	clock.lap(StageWrite)
+	return nil
+}
@@ -0,0 +1,284 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"soxy/wavio"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sineWav returns a 16 bit WAV holding a sine at level dBFS in every
+// channel.
+func sineWav(rate, channels, frames int, freq, level float64) []byte {
+	var data bytes.Buffer
+	amp := math.Pow(10, level/20) * 32767
+	for i := 0; i < frames; i++ {
+		v := int16(math.Round(amp * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))))
+		for c := 0; c < channels; c++ {
+			binary.Write(&data, binary.LittleEndian, v)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+data.Len()))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(wavio.FormatPCM), uint16(channels), uint32(rate),
+		uint32(rate * channels * 2), uint16(channels * 2), uint16(16),
+	} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(data.Len()))
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+func testConfig() Config {
+	var c Config
+	c.Master.Gain = 1
+	c.Master.BitDepth = 24
+	c.Master.SampleRate = 48000
+	c.Master.InternalRate = "96000"
+	c.Master.Dither = "none"
+	return c
+}
+
+// readOutput decodes a processed file and returns its samples.
+func readOutput(t *testing.T, data []byte) (*wavio.Reader, []float64) {
+	t.Helper()
+	r, err := wavio.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []float64
+	buf := make([]float64, 1024)
+	for {
+		n, err := r.ReadFloats(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return r, out
+		}
+		out = append(out, buf[:n]...)
+	}
+}
+
+func peak(data []float64) float64 {
+	p := 0.0
+	for _, v := range data {
+		p = math.Max(p, math.Abs(v))
+	}
+	return 20 * math.Log10(p)
+}
+
+func TestProcess(t *testing.T) {
+	p, err := New(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	report, err := p.Process(context.Background(), bytes.NewReader(sineWav(44100, 2, 44100, 1000, -6)), &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.InputRate != 44100 || report.InternalRate != 96000 {
+		t.Errorf("rates = %d, %d", report.InputRate, report.InternalRate)
+	}
+	r, samples := readOutput(t, out.Bytes())
+	if r.SampleRate != 48000 || r.BitDepth != 24 || r.NumChans != 2 {
+		t.Fatalf("format = %d Hz %d bit %d ch", r.SampleRate, r.BitDepth, r.NumChans)
+	}
+	if frames := len(samples) / 2; frames != 48000 {
+		t.Errorf("frames = %d, want 48000", frames)
+	}
+	if got := peak(samples); math.Abs(got+6) > 0.1 {
+		t.Errorf("peak = %.2f dBFS, want -6", got)
+	}
+}
+
+func TestProcessPeakNorm(t *testing.T) {
+	c := testConfig()
+	c.Master.PeakNorm = "-1"
+	p, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	report, err := p.Process(context.Background(), bytes.NewReader(sineWav(48000, 1, 48000, 997, -20)), &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(report.PeakGain-19) > 0.1 {
+		t.Errorf("peak gain = %.2f dB, want 19", report.PeakGain)
+	}
+	if _, samples := readOutput(t, out.Bytes()); math.Abs(peak(samples)+1) > 0.01 {
+		t.Errorf("peak = %.3f dBFS, want -1", peak(samples))
+	}
+}
+
+func TestProcessStages(t *testing.T) {
+	peakNorm := testConfig()
+	peakNorm.Master.PeakNorm = "-1"
+	tests := []struct {
+		name   string
+		c      Config
+		live   string
+		report string
+	}{
+		{
+			"streamed", testConfig(),
+			"+decode +resample +process +write -decode -resample -process -write",
+			"decode,resample,process,write",
+		},
+		{
+			"normalized", peakNorm,
+			"+decode +resample +process +normalize -decode -resample -process -normalize +write -write",
+			"decode,resample,process,normalize,write",
+		},
+	}
+	for _, tt := range tests {
+		p, err := New(tt.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var live []string
+		took := map[string]time.Duration{}
+		f := func(stage string, finished bool, d time.Duration) {
+			if finished {
+				live = append(live, "-"+stage)
+				took[stage] = d
+			} else {
+				live = append(live, "+"+stage)
+			}
+		}
+		// not seekable, so the copy is timed as part of write too
+		var out bytes.Buffer
+		report, err := p.Process(context.Background(), bytes.NewReader(sineWav(48000, 1, 48000, 997, -20)), &out, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(live, " "); got != tt.live {
+			t.Errorf("%s: live stages = %s, want %s", tt.name, got, tt.live)
+		}
+		var stages []string
+		for _, st := range report.Stages {
+			stages = append(stages, st.Stage)
+			if took[st.Stage] != st.Duration {
+				t.Errorf("%s: %s took %v live, %v in the report", tt.name, st.Stage, took[st.Stage], st.Duration)
+			}
+		}
+		if got := strings.Join(stages, ","); got != tt.report {
+			t.Errorf("%s: report stages = %s, want %s", tt.name, got, tt.report)
+		}
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	p, err := New(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	_, err = p.Process(context.Background(), bytes.NewReader([]byte("not a wav")), &out, nil)
+	if pe, ok := err.(*Error); !ok || pe.Stage != StageDecode {
+		t.Errorf("bad input: got %v, want a decode error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.Process(ctx, bytes.NewReader(sineWav(48000, 1, 48000, 1000, -6)), &out, nil)
+	if pe, ok := err.(*Error); !ok || pe.Stage != StageCanceled || !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: got %v, want a canceled error", err)
+	}
+
+	_, err = p.Process(context.Background(), bytes.NewReader(sineWav(48000, 1, 48000, 1000, -6)), failingWriter{}, nil)
+	if pe, ok := err.(*Error); !ok || pe.Stage != StageWrite {
+		t.Errorf("failing output: got %v, want a write error", err)
+	}
+}
+
+// failingWriter is an output that can seek but not be written.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func (failingWriter) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func TestNew(t *testing.T) {
+	c := testConfig()
+	c.Master.SampleRate = 0
+	c.Master.Bandwidth = 2
+	_, err := New(c)
+	ce, ok := err.(configError)
+	if !ok || len(ce) != 2 {
+		t.Errorf("got %v, want two problems", err)
+	}
+}
+
+func TestWithOverrides(t *testing.T) {
+	c := testConfig()
+	o, err := c.WithOverrides(map[string]string{"master.gain": "0.5", "parametric.0.freq": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Master.Gain != 0.5 || len(o.Parametric) != 1 || o.Parametric[0].Freq != 100 {
+		t.Errorf("overrides not applied: gain %v parametric %v", o.Master.Gain, o.Parametric)
+	}
+	if c.Master.Gain != 1 || len(c.Parametric) != 0 {
+		t.Error("overrides changed the original")
+	}
+	if _, err := c.WithOverrides(map[string]string{"master.nope": "1"}); err == nil {
+		t.Error("unknown key accepted")
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	const hour = 3600 * 48000
+	footprint := func(c Config, frames int64) int64 {
+		t.Helper()
+		n, err := c.Footprint(frames, 2, 48000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	c := testConfig()
+	streamed := footprint(c, 48000)
+	if long := footprint(c, hour); long != streamed {
+		t.Errorf("streamed footprint depends on the length: %d for a second, %d for an hour", streamed, long)
+	}
+	if streamed >= hour*2*8/10 {
+		t.Errorf("streamed footprint %d is not far below the whole file", streamed)
+	}
+	c.Master.InternalRate = "native"
+	if native := footprint(c, hour); native >= streamed {
+		t.Errorf("native rate footprint %d, want below %d with resamplers", native, streamed)
+	}
+
+	c = testConfig()
+	c.Chain = []ChainEntry{{Type: "compressor"}, {Type: "hpf", Freq: 40}, {Type: "Compressor"}}
+	if got, want := footprint(c, hour)-streamed, int64(2*0.3*96000)*2*8; got != want {
+		t.Errorf("two compressors add %d, want their delay lines %d", got, want)
+	}
+
+	c = testConfig()
+	c.Master.PeakNorm = "-1"
+	if got, want := footprint(c, hour)-footprint(c, 48000), int64(hour-48000)*2*8; got != want {
+		t.Errorf("peaknorm grows by %d over an hour, want the whole file %d", got, want)
+	}
+	c.Master.Normalize = true
+	if footprint(c, hour)-footprint(c, 48000) <= int64(hour-48000)*2*8 {
+		t.Error("normalize doesn't keep the loudness history")
+	}
+}
@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"soxy/processor"
+	"soxy/resample"
+	"soxy/resample/polyphase"
+	"soxy/wavio"
+	"strconv"
+	"strings"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/transforms"
+)
+
+// BlockSize is the number of frames read from the input at a time.  The
+// soxy command reads files in blocks of the same size.
+const BlockSize = 4096
+
+// defaultInternalRate is the rate the chain runs at unless [master] says
+// otherwise.
+const defaultInternalRate = 192000
+
+// internalRate returns the rate the chain runs at for a file at inRate.
+func internalRate(c Config, inRate int) (int, error) {
+	ir := c.Master.InternalRate
+	switch {
+	case ir != "" && c.Master.Oversampling != 0:
+		return 0, errors.New("master: use either internalrate or oversampling, not both")
+	case c.Master.Oversampling < 0:
+		return 0, fmt.Errorf("master.oversampling: %d is not a positive factor", c.Master.Oversampling)
+	case c.Master.Oversampling > 0:
+		return inRate * c.Master.Oversampling, nil
+	case ir == "":
+		return defaultInternalRate, nil
+	case strings.EqualFold(ir, "native"):
+		return inRate, nil
+	}
+	rate, err := strconv.Atoi(ir)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("master.internalrate: %q is not a sample rate or \"native\"", ir)
+	}
+	return rate, nil
+}
+
+// newResampler converts interleaved audio with numChans channels from
+// inRate to outRate using the filter knobs in [master].
+func newResampler(c Config, numChans, inRate, outRate int) (resample.Resampler, error) {
+	if inRate == outRate {
+		return resample.Passthrough{}, nil
+	}
+	f, err := polyphase.NewFilter(inRate, outRate, c.filterParams())
+	if err != nil {
+		return nil, err
+	}
+	return resample.NewInterleaved(numChans, func() resample.Resampler {
+		return polyphase.NewResampler(f)
+	}), nil
+}
+
+// filterParams returns the resampling filter knobs in [master].
+func (c Config) filterParams() polyphase.Params {
+	return polyphase.Params{
+		Bandwidth:         c.Master.Bandwidth,
+		RippleFactor:      c.Master.RippleFactor,
+		RippleAttenuation: c.Master.RippleAttenuation,
+		Tolerance:         c.Master.Tolerance,
+	}
+}
+
+// processStream reads dec one block at a time, applies the master gain,
+// resamples up to the internal rate, runs the chain, resamples down to the
+// output rate and hands every block to sink as soon as it is ready, with
+// 1.0 as full scale.  Filter, compressor and resampler state carries over
+// from block to block.  clock gets the decode, resample and process laps;
+// sink takes its own.  Errors are *Error values naming the stage; errors
+// from sink are returned as they are.
+func processStream(ctx context.Context, c Config, chain processor.Chain, up, down resample.Resampler, dec *wavio.Reader, sink func([]float64) error, clock *stageClock) error {
+	bitDepth := float64(dec.BitDepth)
+	format := dec.Format()
+	write := func(buff *audio.FloatBuffer, last bool) error {
+		if len(buff.Data) > 0 {
+			chain.ProcessBlock(buff)
+		}
+		clock.lap(StageProcess)
+		data := down.Resample(buff.Data)
+		if last {
+			data = append(data, down.Flush()...)
+		}
+		clock.lap(StageResample)
+		if len(data) == 0 {
+			return nil
+		}
+		return sink(fullScale(data))
+	}
+
+	in := &audio.IntBuffer{Format: format, Data: make([]int, BlockSize*format.NumChannels)}
+	for {
+		if err := ctx.Err(); err != nil {
+			return &Error{Stage: StageCanceled, Err: err}
+		}
+		n, err := dec.PCMBuffer(in)
+		if err != nil {
+			return &Error{Stage: StageDecode, Err: err}
+		}
+		if n == 0 {
+			break
+		}
+		// convert to float buffer with range -1 to 1
+		buff := toFloatBuffer(&audio.IntBuffer{Format: format, Data: in.Data[:n]}, bitDepth)
+		transforms.Gain(buff, c.Master.Gain)
+		clock.lap(StageDecode)
+		buff.Data = up.Resample(buff.Data)
+		clock.lap(StageResample)
+		if err := write(buff, false); err != nil {
+			return err
+		}
+	}
+	clock.lap(StageDecode)
+	flushed := up.Flush()
+	clock.lap(StageResample)
+	return write(&audio.FloatBuffer{Format: format, Data: flushed}, true)
+}
+
+// toFloatBuffer converts the buffer to the usable format for
+// processing.  The encoder requantizes the result when the file is
+// written back to disk.
+func toFloatBuffer(buf *audio.IntBuffer, bitDepth float64) *audio.FloatBuffer {
+	newB := &audio.FloatBuffer{}
+	newB.Data = make([]float64, len(buf.Data))
+	for i := 0; i < len(buf.Data); i++ {
+		newB.Data[i] = float64(buf.Data[i]) / math.Pow(2, bitDepth)
+	}
+	newB.Format = &audio.Format{
+		NumChannels: buf.Format.NumChannels,
+		SampleRate:  buf.Format.SampleRate,
+	}
+	return newB
+}
+
+// fullScale returns a copy of data scaled so 1.0 is full scale.
+// toFloatBuffer divides by 2^bitDepth which leaves full scale at 0.5.
+func fullScale(data []float64) []float64 {
+	scaled := make([]float64, len(data))
+	for i, v := range data {
+		scaled[i] = 2 * v
+	}
+	return scaled
+}
+
+// applyGain streams the float WAV in r into write scaled by gain dB.
+func applyGain(ctx context.Context, r io.Reader, write func([]float64) error, gain float64) error {
+	dec, err := wavio.NewReader(r)
+	if err != nil {
+		return err
+	}
+	mult := math.Pow(10, gain/20)
+	block := make([]float64, BlockSize*int(dec.NumChans))
+	for {
+		if err := ctx.Err(); err != nil {
+			return &Error{Stage: StageCanceled, Err: err}
+		}
+		n, err := dec.ReadFloats(block)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		for i := range block[:n] {
+			block[i] *= mult
+		}
+		if err := write(block[:n]); err != nil {
+			return err
+		}
+	}
+}
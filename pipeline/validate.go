@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"soxy/biquad/bsf"
+	"soxy/biquad/hpf"
+	"soxy/biquad/lpf"
+	"soxy/biquad/parametric"
+	"soxy/compressor"
+	"soxy/dither"
+	"soxy/processor"
+	"strconv"
+	"strings"
+)
+
+// maxCompressorGain bounds the compressor's inputgain and outputgain in dB.
+const maxCompressorGain = 60.0
+
+// configError lists every problem found in a config, each starting with
+// the key it is about.
+type configError []string
+
+func (e configError) Error() string {
+	if len(e) == 1 {
+		return "config: " + e[0]
+	}
+	return fmt.Sprintf("config: %d problems:\n  %s", len(e), strings.Join(e, "\n  "))
+}
+
+// problems collects the messages for a configError.
+type problems []string
+
+func (p *problems) add(key, format string, args ...interface{}) {
+	*p = append(*p, key+": "+fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return configError(p)
+}
+
+// Ranges accepted for the loudness targets, the same as ffmpeg's loudnorm.
+var loudnessLimits = []struct {
+	key      string
+	min, max float64
+}{
+	{"integratedloudness", -70, -5},
+	{"loudnessrange", 1, 50},
+	{"truepeak", -9, 0},
+}
+
+// validate checks the whole config before any file is touched and reports
+// every problem at once.  Filter frequencies can only be checked here when
+// the internal rate is fixed; with "native" or oversampling checkRate runs
+// again for every file.
+func (c Config) Validate() error {
+	var p problems
+	m := c.Master
+
+	if m.SampleRate <= 0 {
+		p.add("master.samplerate", "%d is not a sample rate", m.SampleRate)
+	}
+	bits := int(m.BitDepth)
+	switch {
+	case float64(bits) != m.BitDepth:
+		p.add("master.bitdepth", "%v is not a whole number", m.BitDepth)
+	case m.Float && bits != 32 && bits != 64:
+		p.add("master.bitdepth", "float output is 32 or 64 bit, not %d", bits)
+	case !m.Float && bits != 8 && bits != 16 && bits != 24 && bits != 32:
+		p.add("master.bitdepth", "%d is not 8, 16, 24 or 32 (set float=true for 64 bit float)", bits)
+	}
+	if m.Gain <= 0 {
+		p.add("master.gain", "%v must be above 0", m.Gain)
+	}
+
+	// resampler
+	if m.Bandwidth != 0 && (m.Bandwidth < 0.5 || m.Bandwidth >= 1) {
+		p.add("master.bandwidth", "%v is not between 0.5 and 1 (0.95 - 0.99 is typical)", m.Bandwidth)
+	}
+	if m.RippleFactor < 0 || m.RippleFactor > 3 {
+		p.add("master.ripplefactor", "%v is not between 0 and 3 dB (0.01 - 0.1 is typical)", m.RippleFactor)
+	}
+	if m.RippleAttenuation != 0 && (m.RippleAttenuation < 20 || m.RippleAttenuation > 200) {
+		p.add("master.rippleattenuation", "%v is not between 20 and 200 dB (100 - 159 is typical)", m.RippleAttenuation)
+	}
+	if m.Tolerance < 0 || m.Tolerance > 0.01 {
+		p.add("master.tolerance", "%v is not between 0 and 0.01", m.Tolerance)
+	}
+	rate, err := internalRate(c, 1)
+	if err != nil {
+		p = append(p, err.Error())
+	}
+	if m.Oversampling > 16 {
+		p.add("master.oversampling", "%d is more than 16", m.Oversampling)
+	}
+	fixedRate := m.Oversampling == 0 && !strings.EqualFold(m.InternalRate, "native")
+
+	// output
+	if !m.Float {
+		if _, err := dither.New(16, 1, m.Dither, "", 0); err != nil {
+			p.add("master.dither", "%s", strings.TrimPrefix(err.Error(), "dither: "))
+		}
+		if _, err := dither.New(16, 1, "", m.NoiseShaping, 0); err != nil {
+			p.add("master.noiseshaping", "%s", strings.TrimPrefix(err.Error(), "dither: "))
+		}
+	}
+
+	// normalization
+	values := map[string]string{
+		"integratedloudness": m.IntegratedLoudness,
+		"loudnessrange":      m.LoudnessRange,
+		"truepeak":           m.TruePeak,
+	}
+	for _, l := range loudnessLimits {
+		v, err := parseTarget(l.key, values[l.key], 0)
+		if err != nil {
+			p = append(p, err.Error())
+		} else if values[l.key] != "" && (v < l.min || v > l.max) {
+			p.add("master."+l.key, "%v is not between %v and %v", v, l.min, l.max)
+		}
+	}
+	if m.PeakNorm != "" {
+		if v, err := strconv.ParseFloat(m.PeakNorm, 64); err != nil {
+			p.add("master.peaknorm", "%q is not a number", m.PeakNorm)
+		} else if v > 0 {
+			p.add("master.peaknorm", "%v is above 0 dBFS", v)
+		}
+	}
+	if mode := strings.ToLower(m.PeakMode); mode != "" && mode != "sample" && mode != "true" {
+		p.add("master.peakmode", "%q is not sample or true", m.PeakMode)
+	}
+	if m.SoxNorm || m.SoxNormTo != "" {
+		p.add("master.soxnorm", "sox is no longer used for normalization, set peaknorm instead")
+	}
+
+	// processors
+	named, err := c.namedProcessors()
+	if err != nil {
+		p = append(p, strings.TrimPrefix(err.Error(), "config: "))
+	}
+	for _, n := range named {
+		checkProcessor(&p, n.key, n.p)
+	}
+	if err == nil && fixedRate && rate > 0 {
+		p = append(p, c.checkRate(rate)...)
+	}
+	return p.err()
+}
+
+// namedProcessor is a processor with the config key it came from.
+type namedProcessor struct {
+	key string
+	p   processor.Processor
+}
+
+func (c Config) namedProcessors() ([]namedProcessor, error) {
+	chain, err := c.chain()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if len(c.Chain) != 0 {
+		for i := range c.Chain {
+			keys = append(keys, fmt.Sprintf("chain[%d]", i))
+		}
+	} else {
+		if c.HPF != nil {
+			keys = append(keys, "hpf")
+		}
+		if c.LPF != nil {
+			keys = append(keys, "lpf")
+		}
+		for i := range c.Parametric {
+			keys = append(keys, fmt.Sprintf("parametric[%d]", i))
+		}
+		if c.Compressor != nil {
+			keys = append(keys, "compressor")
+		}
+	}
+	named := make([]namedProcessor, len(chain))
+	for i, p := range chain {
+		named[i] = namedProcessor{key: keys[i], p: p}
+	}
+	return named, nil
+}
+
+// checkProcessor checks the settings that don't depend on the rate.
+func checkProcessor(p *problems, key string, proc processor.Processor) {
+	switch f := proc.(type) {
+	case *hpf.HPF:
+		// 0 leaves the signal alone
+		if f.Freq < 0 {
+			p.add(key+".freq", "%v is below 0 Hz", f.Freq)
+		}
+	case *lpf.LPF:
+		if f.Freq <= 0 {
+			p.add(key+".freq", "%v is not above 0 Hz", f.Freq)
+		}
+	case *parametric.Parametric:
+		if f.Freq <= 0 {
+			p.add(key+".freq", "%v is not above 0 Hz", f.Freq)
+		}
+		if f.Q <= 0 {
+			p.add(key+".q", "%v must be above 0", f.Q)
+		}
+	case *bsf.BSF:
+		if f.Freq <= 0 {
+			p.add(key+".freq", "%v is not above 0 Hz", f.Freq)
+		}
+		if f.Q < 0 {
+			p.add(key+".q", "%v is below 0", f.Q)
+		}
+	case *compressor.Compressor:
+		if f.Ratio < 1 {
+			p.add(key+".ratio", "%v is below 1", f.Ratio)
+		}
+		if f.AttackTime <= 0 {
+			p.add(key+".attacktime", "%v ms must be above 0", f.AttackTime)
+		}
+		if f.ReleaseTime <= 0 {
+			p.add(key+".releasetime", "%v ms must be above 0", f.ReleaseTime)
+		}
+		if f.Knee < 0 {
+			p.add(key+".knee", "%v is below 0", f.Knee)
+		}
+		if f.Threshold > 0 {
+			p.add(key+".threshold", "%v is above 0 dB", f.Threshold)
+		}
+		// the gains are in dB
+		if math.Abs(f.InputGain) > maxCompressorGain {
+			p.add(key+".inputgain", "%v dB is not between -%v and %v", f.InputGain, maxCompressorGain, maxCompressorGain)
+		}
+		if math.Abs(f.OutputGain) > maxCompressorGain {
+			p.add(key+".outputgain", "%v dB is not between -%v and %v", f.OutputGain, maxCompressorGain, maxCompressorGain)
+		}
+		if f.StereoLink < compressor.Unlinked || f.StereoLink > compressor.LinkRMS {
+			p.add(key+".stereolink", "%d is not 0, 1, 2 or 3", f.StereoLink)
+		}
+	}
+}
+
+// checkRate returns the filters whose frequency is at or above the Nyquist
+// frequency of the internal rate.
+func (c Config) checkRate(rate int) []string {
+	named, err := c.namedProcessors()
+	if err != nil {
+		return nil
+	}
+	var p problems
+	nyquist := float64(rate) / 2
+	for _, n := range named {
+		var freq float64
+		switch f := n.p.(type) {
+		case *hpf.HPF:
+			freq = f.Freq
+		case *lpf.LPF:
+			freq = f.Freq
+		case *parametric.Parametric:
+			freq = f.Freq
+		case *bsf.BSF:
+			freq = f.Freq
+		default:
+			continue
+		}
+		if freq >= nyquist || math.IsNaN(freq) {
+			p.add(n.key+".freq", "%v Hz is not below %v Hz, half the internal rate of %d", freq, nyquist, rate)
+		}
+	}
+	return p
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"github.com/go-audio/audio"
+)
+
+// Processor is implemented by every effect that can be placed in a chain.
+type Processor interface {
+	// Prepare sets up coefficients and state for the given rate and channel count.
+	Prepare(sampleRate float64, numChannels int)
+	// ProcessBlock processes the buffer in place.
+	ProcessBlock(buf *audio.FloatBuffer)
+	// Reset clears any state carried between blocks.
+	Reset()
+	// Latency reports the delay introduced by the processor in samples.
+	Latency() int
+}
+
+// Chain runs processors one after another in the order given.
+type Chain []Processor
+
+// Prepare prepares every processor in the chain.
+func (c Chain) Prepare(sampleRate float64, numChannels int) {
+	for _, p := range c {
+		p.Prepare(sampleRate, numChannels)
+	}
+}
+
+// ProcessBlock runs the buffer through every processor in order.
+func (c Chain) ProcessBlock(buf *audio.FloatBuffer) {
+	for _, p := range c {
+		p.ProcessBlock(buf)
+	}
+}
+
+// Reset resets every processor in the chain.
+func (c Chain) Reset() {
+	for _, p := range c {
+		p.Reset()
+	}
+}
+
+// Latency is the sum of the latencies of the processors in the chain.
+func (c Chain) Latency() int {
+	latency := 0
+	for _, p := range c {
+		latency += p.Latency()
+	}
+	return latency
+}
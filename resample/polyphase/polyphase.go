@@ -0,0 +1,298 @@
+package polyphase
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxPhases limits the interpolation factor so the filter stays a sensible
+// size.  Ratios that need more phases are approximated within Tolerance.
+const maxPhases = 1024
+
+// Defaults used for knobs that are left at zero.
+const (
+	DefaultBandwidth         = 0.95
+	DefaultRippleFactor      = 0.1
+	DefaultRippleAttenuation = 140.0
+	DefaultTolerance         = 0.000001
+)
+
+// Params are the filter knobs from the [master] section.
+type Params struct {
+	// Bandwidth is the passband edge as a fraction of the lower Nyquist
+	// frequency, 0.5 - 0.999.
+	Bandwidth float64
+	// RippleFactor is the allowed passband ripple in dB.
+	RippleFactor float64
+	// RippleAttenuation is the stopband attenuation in dB.
+	RippleAttenuation float64
+	// Tolerance is the relative error allowed when the exact rate ratio
+	// would need more than maxPhases filter phases.
+	Tolerance float64
+}
+
+func (p Params) withDefaults() Params {
+	if p.Bandwidth == 0 {
+		p.Bandwidth = DefaultBandwidth
+	}
+	if p.RippleFactor == 0 {
+		p.RippleFactor = DefaultRippleFactor
+	}
+	if p.RippleAttenuation == 0 {
+		p.RippleAttenuation = DefaultRippleAttenuation
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = DefaultTolerance
+	}
+	return p
+}
+
+// Filter is the polyphase decomposition of a Kaiser windowed sinc low pass
+// for one rate conversion.  It is read only and can be shared by any
+// number of Resamplers.
+type Filter struct {
+	// L and M are the interpolation and decimation factors.
+	L, M int
+	// delay is the group delay of the prototype in upsampled samples.
+	delay int64
+	// phases holds taps coefficients per phase, reversed so they line up
+	// with the input history.
+	phases [][]float64
+	taps   int
+}
+
+// prototype is the low pass a Filter is made from, before its
+// coefficients are computed.
+type prototype struct {
+	L, M int
+	// half is the group delay, the prototype has 2*half+1 taps
+	half         int64
+	cutoff, beta float64
+}
+
+// taps is the number of coefficients per phase.
+func (pr prototype) taps() int {
+	return int((2*pr.half + int64(pr.L)) / int64(pr.L))
+}
+
+// plan works out the prototype of the filter converting inRate to outRate.
+func plan(inRate, outRate int, p Params) (prototype, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return prototype{}, fmt.Errorf("polyphase: bad rates %d -> %d", inRate, outRate)
+	}
+	p = p.withDefaults()
+	if p.Bandwidth < 0.5 || p.Bandwidth >= 1 {
+		return prototype{}, fmt.Errorf("polyphase: bandwidth %v is not in [0.5, 1)", p.Bandwidth)
+	}
+	if p.RippleFactor < 0 || p.RippleAttenuation < 0 || p.Tolerance < 0 {
+		return prototype{}, errors.New("polyphase: ripple, attenuation and tolerance must be positive")
+	}
+	L, M, err := ratio(inRate, outRate, p.Tolerance)
+	if err != nil {
+		return prototype{}, err
+	}
+
+	// Work at the upsampled rate L*inRate, normalised to 1.  The passband
+	// ends at Bandwidth of the lower Nyquist and the stopband starts at
+	// that Nyquist, so nothing aliases back into the passband.
+	nyquist := 0.5 / float64(L)
+	if M > L {
+		nyquist = 0.5 / float64(M)
+	}
+	pass := p.Bandwidth * nyquist
+	cutoff := (pass + nyquist) / 2
+	transition := 2 * math.Pi * (nyquist - pass)
+
+	// Kaiser's estimates.  The ripple factor sets an attenuation of its own
+	// and the stricter of the two wins.
+	atten := p.RippleAttenuation
+	if p.RippleFactor > 0 {
+		if a := -20 * math.Log10(math.Pow(10, p.RippleFactor/20)-1); a > atten {
+			atten = a
+		}
+	}
+	beta := 0.0
+	switch {
+	case atten > 50:
+		beta = 0.1102 * (atten - 8.7)
+	case atten >= 21:
+		beta = 0.5842*math.Pow(atten-21, 0.4) + 0.07886*(atten-21)
+	}
+	half := int64(math.Ceil((atten - 7.95) / (2.285 * transition) / 2))
+	if half < int64(L) {
+		half = int64(L)
+	}
+	return prototype{L: L, M: M, half: half, cutoff: cutoff, beta: beta}, nil
+}
+
+// NewFilter designs the filter converting inRate to outRate.
+func NewFilter(inRate, outRate int, p Params) (*Filter, error) {
+	pr, err := plan(inRate, outRate, p)
+	if err != nil {
+		return nil, err
+	}
+	L, half, cutoff, beta := pr.L, pr.half, pr.cutoff, pr.beta
+	f := &Filter{L: L, M: pr.M, delay: half}
+	n := 2*half + 1
+	f.taps = pr.taps()
+	f.phases = make([][]float64, L)
+	for ph := range f.phases {
+		f.phases[ph] = make([]float64, f.taps)
+	}
+	i0 := besselI0(beta)
+	for k := int64(0); k < n; k++ {
+		x := float64(k - half)
+		h := 2 * cutoff
+		if x != 0 {
+			h = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		r := x / float64(half)
+		w := besselI0(beta*math.Sqrt(1-r*r)) / i0
+		// the zeros stuffed in by upsampling cost a factor of L in gain
+		ph, t := int(k%int64(L)), int(k/int64(L))
+		f.phases[ph][f.taps-1-t] = h * w * float64(L)
+	}
+	return f, nil
+}
+
+// Size returns the number of coefficients of the filter NewFilter designs
+// for the same arguments and its taps per phase, which is also about the
+// input history each Resampler using it keeps, without designing it.
+func Size(inRate, outRate int, p Params) (coefficients, taps int, err error) {
+	pr, err := plan(inRate, outRate, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pr.L * pr.taps(), pr.taps(), nil
+}
+
+// ratio returns L and M with L/M equal to outRate/inRate, or within
+// tolerance of it when the exact fraction needs too many phases.
+func ratio(inRate, outRate int, tolerance float64) (int, int, error) {
+	g := gcd(inRate, outRate)
+	if L, M := outRate/g, inRate/g; L <= maxPhases {
+		return L, M, nil
+	}
+	// walk the continued fraction convergents of the ratio
+	r := float64(outRate) / float64(inRate)
+	h0, h1 := 0, 1
+	k0, k1 := 1, 0
+	x := r
+	for {
+		a := int(math.Floor(x))
+		h0, h1 = h1, a*h1+h0
+		k0, k1 = k1, a*k1+k0
+		if h1 > maxPhases {
+			break
+		}
+		if math.Abs(float64(h1)/float64(k1)-r) <= tolerance*r {
+			return h1, k1, nil
+		}
+		frac := x - float64(a)
+		if frac == 0 {
+			break
+		}
+		x = 1 / frac
+	}
+	return 0, 0, fmt.Errorf("polyphase: can't convert %d -> %d within tolerance %v", inRate, outRate, tolerance)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
+
+// Resampler converts one channel with a Filter.  It implements
+// resample.Resampler: the input can be fed in blocks of any size and only
+// a filter length of history is kept.  The filter delay is compensated, so
+// output sample m lines up with input time m*M/L.
+type Resampler struct {
+	f *Filter
+	// history of the input, hist[0] is input sample offset
+	hist   []float64
+	offset int64
+	// pos is the position of the next output in upsampled samples
+	pos      int64
+	consumed int64
+	produced int64
+}
+
+// New designs a filter and returns a Resampler using it.
+func New(inRate, outRate int, p Params) (*Resampler, error) {
+	f, err := NewFilter(inRate, outRate, p)
+	if err != nil {
+		return nil, err
+	}
+	return NewResampler(f), nil
+}
+
+// NewResampler returns a Resampler using f.
+func NewResampler(f *Filter) *Resampler {
+	return &Resampler{
+		f: f,
+		// the first outputs look back before the start of the signal
+		hist:   make([]float64, f.taps-1),
+		offset: -int64(f.taps - 1),
+		pos:    f.delay,
+	}
+}
+
+// Resample consumes in and returns the output that is ready so far.
+func (r *Resampler) Resample(in []float64) []float64 {
+	r.hist = append(r.hist, in...)
+	r.consumed += int64(len(in))
+	return r.run(-1)
+}
+
+// Flush returns the remaining output once the input has ended.  The total
+// output is ceil(inputs * L / M) samples.
+func (r *Resampler) Flush() []float64 {
+	want := (r.consumed*int64(r.f.L) + int64(r.f.M) - 1) / int64(r.f.M)
+	// pad with enough silence to reach the filter's last tap
+	r.hist = append(r.hist, make([]float64, r.f.delay/int64(r.f.L)+int64(r.f.taps)+1)...)
+	return r.run(want)
+}
+
+// run produces output until the history runs out or limit outputs have
+// been produced in total (limit < 0 means no limit).
+func (r *Resampler) run(limit int64) []float64 {
+	f := r.f
+	L, M := int64(f.L), int64(f.M)
+	var out []float64
+	for limit < 0 || r.produced < limit {
+		last := r.pos / L
+		if last >= r.offset+int64(len(r.hist)) {
+			break
+		}
+		x := r.hist[last-int64(f.taps-1)-r.offset:]
+		y := 0.0
+		for t, h := range f.phases[r.pos%L] {
+			y += h * x[t]
+		}
+		out = append(out, y)
+		r.pos += M
+		r.produced++
+	}
+	// drop history no later output needs
+	if drop := r.pos/L - int64(f.taps-1) - r.offset; drop > 0 {
+		if drop > int64(len(r.hist)) {
+			drop = int64(len(r.hist))
+		}
+		r.hist = r.hist[:copy(r.hist, r.hist[drop:])]
+		r.offset += drop
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package polyphase
+
+import (
+	"math"
+	"testing"
+)
+
+func sine(rate, freq float64, n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = 0.5 * math.Sin(2*math.Pi*freq*float64(i)/rate)
+	}
+	return data
+}
+
+// resampleBlocks feeds in to r in blocks of size.
+func resampleBlocks(r *Resampler, in []float64, size int) []float64 {
+	var out []float64
+	for len(in) > 0 {
+		n := size
+		if n > len(in) {
+			n = len(in)
+		}
+		out = append(out, r.Resample(in[:n])...)
+		in = in[n:]
+	}
+	return append(out, r.Flush()...)
+}
+
+func TestConvert(t *testing.T) {
+	for _, tc := range []struct{ in, out int }{
+		{44100, 48000},
+		{48000, 44100},
+		{16000, 192000},
+		{192000, 44100},
+	} {
+		r, err := New(tc.in, tc.out, Params{Bandwidth: 0.95, RippleAttenuation: 120})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := tc.in / 2
+		out := resampleBlocks(r, sine(float64(tc.in), 1000, n), 1000)
+		if want := (n*tc.out + tc.in - 1) / tc.in; len(out) != want {
+			t.Errorf("%d -> %d: %d samples, want %d", tc.in, tc.out, len(out), want)
+		}
+		// away from the edges the output is the same sine, in time
+		ideal := sine(float64(tc.out), 1000, len(out))
+		worst := 0.0
+		for i := len(out) / 4; i < len(out)*3/4; i++ {
+			worst = math.Max(worst, math.Abs(out[i]-ideal[i]))
+		}
+		if worst > 1e-4 {
+			t.Errorf("%d -> %d: error %g", tc.in, tc.out, worst)
+		}
+	}
+}
+
+func TestStopband(t *testing.T) {
+	// 30 kHz can't exist at 44.1 kHz and has to be filtered out.
+	r, err := New(96000, 44100, Params{Bandwidth: 0.95, RippleAttenuation: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := resampleBlocks(r, sine(96000, 30000, 96000), 4096)
+	peak := 0.0
+	for _, v := range out[1000 : len(out)-1000] {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if db := 20 * math.Log10(peak/0.5); db > -95 {
+		t.Errorf("alias at %.1f dB", db)
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	in := sine(44100, 440, 10000)
+	f, err := NewFilter(44100, 48000, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := resampleBlocks(NewResampler(f), in, len(in))
+	small := resampleBlocks(NewResampler(f), in, 7)
+	if len(whole) != len(small) {
+		t.Fatalf("%d samples in blocks, %d at once", len(small), len(whole))
+	}
+	for i := range whole {
+		if whole[i] != small[i] {
+			t.Fatalf("sample %d differs: %v vs %v", i, small[i], whole[i])
+		}
+	}
+}
+
+func TestRatio(t *testing.T) {
+	if L, M, _ := ratio(44100, 48000, 0); L != 160 || M != 147 {
+		t.Errorf("44100 -> 48000 = %d/%d, want 160/147", L, M)
+	}
+	L, M, err := ratio(44100, 44101, 1e-4)
+	if err != nil || math.Abs(float64(L)/float64(M)-44101.0/44100) > 1e-4 {
+		t.Errorf("44100 -> 44101 = %d/%d %v", L, M, err)
+	}
+	if _, _, err := ratio(44100, 44101, 1e-9); err == nil {
+		t.Error("44100 -> 44101 at 1e-9: no error")
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, r := range [][2]int{{44100, 48000}, {48000, 192000}, {192000, 44100}} {
+		f, err := NewFilter(r[0], r[1], Params{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		coefs, taps, err := Size(r[0], r[1], Params{})
+		if err != nil || taps != f.taps || coefs != f.L*f.taps {
+			t.Errorf("%d -> %d: size %d/%d %v, want %d/%d", r[0], r[1], coefs, taps, err, f.L*f.taps, f.taps)
+		}
+	}
+}
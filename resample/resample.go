@@ -0,0 +1,85 @@
+package resample
+
+// Resampler converts a mono stream from one sample rate to another.  Filter
+// state is kept between calls so a signal can be fed one block at a time.
+type Resampler interface {
+	// Resample consumes in and returns the output that is ready so far.
+	Resample(in []float64) []float64
+	// Flush returns the remaining output once the input has ended.
+	Flush() []float64
+}
+
+// Interleaved runs one Resampler per channel over interleaved audio.
+type Interleaved struct {
+	channels []Resampler
+	split    [][]float64
+	pending  [][]float64
+}
+
+// NewInterleaved creates numChannels resamplers with newResampler.
+func NewInterleaved(numChannels int, newResampler func() Resampler) *Interleaved {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	r := &Interleaved{
+		channels: make([]Resampler, numChannels),
+		split:    make([][]float64, numChannels),
+		pending:  make([][]float64, numChannels),
+	}
+	for ch := range r.channels {
+		r.channels[ch] = newResampler()
+	}
+	return r
+}
+
+// Resample de-interleaves in, resamples every channel and returns the
+// frames that are complete on all channels.
+func (r *Interleaved) Resample(in []float64) []float64 {
+	numChannels := len(r.channels)
+	for ch := range r.split {
+		r.split[ch] = r.split[ch][:0]
+	}
+	for i, v := range in {
+		r.split[i%numChannels] = append(r.split[i%numChannels], v)
+	}
+	for ch, rs := range r.channels {
+		r.pending[ch] = append(r.pending[ch], rs.Resample(r.split[ch])...)
+	}
+	return r.interleave()
+}
+
+// Flush drains every channel.
+func (r *Interleaved) Flush() []float64 {
+	for ch, rs := range r.channels {
+		r.pending[ch] = append(r.pending[ch], rs.Flush()...)
+	}
+	return r.interleave()
+}
+
+func (r *Interleaved) interleave() []float64 {
+	numChannels := len(r.channels)
+	frames := len(r.pending[0])
+	for _, p := range r.pending[1:] {
+		if len(p) < frames {
+			frames = len(p)
+		}
+	}
+	out := make([]float64, frames*numChannels)
+	for ch, p := range r.pending {
+		for i := 0; i < frames; i++ {
+			out[i*numChannels+ch] = p[i]
+		}
+		r.pending[ch] = p[:copy(p, p[frames:])]
+	}
+	return out
+}
+
+// Passthrough is the Resampler used when the rates already match.  It
+// works on interleaved audio as well as mono.
+type Passthrough struct{}
+
+// Resample returns in unchanged.
+func (Passthrough) Resample(in []float64) []float64 { return in }
+
+// Flush has nothing to return.
+func (Passthrough) Flush() []float64 { return nil }
@@ -0,0 +1,363 @@
+package wavio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/go-audio/audio"
+)
+
+// Format tags found in the fmt chunk.
+const (
+	FormatPCM        = 0x0001
+	FormatIEEEFloat  = 0x0003
+	FormatExtensible = 0xFFFE
+)
+
+// maxChunkSize is the largest size a chunk can claim.  Writers that stream
+// their output often leave 0 or this value in the data chunk header.
+const maxChunkSize = 0xFFFFFFFF
+
+// Chunk describes a RIFF chunk found while reading the header.
+type Chunk struct {
+	ID   string
+	Size uint32
+}
+
+// Reader decodes PCM and IEEE float WAV files.  It reads the input strictly
+// front to back so it also works on pipes, and it tolerates the header
+// problems found in recorded corpora:
+//   - RIFF and data sizes that are zero, 0xFFFFFFFF or larger than the file
+//     (the data is read until EOF)
+//   - fmt chunks that are longer than the fields they carry
+//   - chunks that are not padded to an even size
+//   - WAVE_FORMAT_EXTENSIBLE headers, which are resolved to their sub format
+//   - a trailing partial frame, which is dropped
+type Reader struct {
+	// WavAudioFormat is FormatPCM or FormatIEEEFloat - extensible headers
+	// are reported as their sub format.
+	WavAudioFormat uint16
+	NumChans       uint16
+	SampleRate     uint32
+	BitDepth       uint16
+	// Extensible is set when the header used WAVE_FORMAT_EXTENSIBLE.
+	Extensible bool
+	// DataSize is the size of the data chunk in bytes or -1 when the header
+	// does not give a usable size.
+	DataSize int64
+	// Chunks lists the chunks seen up to and including the data chunk.
+	Chunks []Chunk
+
+	r         *bufio.Reader
+	remaining int64
+	raw       []byte
+}
+
+// NewReader reads the header of a WAV file and leaves r positioned at the
+// first sample.
+func NewReader(r io.Reader) (*Reader, error) {
+	w := &Reader{r: bufio.NewReader(r), DataSize: -1}
+	var riff [12]byte
+	if _, err := io.ReadFull(w.r, riff[:]); err != nil {
+		return nil, fmt.Errorf("wavio: reading RIFF header: %v", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("wavio: not a RIFF/WAVE file")
+	}
+
+	haveFmt := false
+	for {
+		id, size, err := w.nextChunk()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.New("wavio: no data chunk")
+		}
+		if err != nil {
+			return nil, err
+		}
+		w.Chunks = append(w.Chunks, Chunk{ID: id, Size: size})
+		switch id {
+		case "fmt ":
+			if err := w.readFmt(size); err != nil {
+				return nil, err
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, errors.New("wavio: data chunk before fmt chunk")
+			}
+			w.remaining = -1
+			if size != 0 && size != maxChunkSize {
+				w.remaining = int64(size)
+				w.DataSize = int64(size)
+			}
+			return w, nil
+		default:
+			if err := w.skip(int64(size)); err != nil {
+				return nil, errors.New("wavio: no data chunk")
+			}
+			w.skipPad(size)
+		}
+	}
+}
+
+// nextChunk reads a chunk header.  When the id is not printable - usually
+// because the previous chunk lied about its size - the reader slides
+// forward one byte at a time until it finds a plausible id.
+func (w *Reader) nextChunk() (string, uint32, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(w.r, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	for !isChunkID(hdr[:4]) {
+		copy(hdr[:], hdr[1:])
+		b, err := w.r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		hdr[7] = b
+	}
+	return string(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:]), nil
+}
+
+func isChunkID(id []byte) bool {
+	for _, c := range id {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Reader) readFmt(size uint32) error {
+	if size < 16 {
+		return fmt.Errorf("wavio: fmt chunk too short (%d bytes)", size)
+	}
+	// Anything past the fields we know about is skipped, so read at most
+	// the extensible layout.
+	n := int64(size)
+	if n > 40 {
+		n = 40
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(w.r, buf); err != nil {
+		return fmt.Errorf("wavio: reading fmt chunk: %v", err)
+	}
+	if err := w.skip(int64(size) - n); err != nil {
+		return fmt.Errorf("wavio: reading fmt chunk: %v", err)
+	}
+	w.skipPad(size)
+
+	w.WavAudioFormat = binary.LittleEndian.Uint16(buf[0:2])
+	w.NumChans = binary.LittleEndian.Uint16(buf[2:4])
+	w.SampleRate = binary.LittleEndian.Uint32(buf[4:8])
+	w.BitDepth = binary.LittleEndian.Uint16(buf[14:16])
+	if w.WavAudioFormat == FormatExtensible {
+		if len(buf) < 26 {
+			return errors.New("wavio: extensible fmt chunk without a sub format")
+		}
+		w.Extensible = true
+		// The sub format GUID starts with the plain format tag.
+		w.WavAudioFormat = binary.LittleEndian.Uint16(buf[24:26])
+	}
+
+	if w.NumChans == 0 {
+		return errors.New("wavio: fmt chunk has no channels")
+	}
+	if w.SampleRate == 0 {
+		return errors.New("wavio: fmt chunk has no sample rate")
+	}
+	switch w.WavAudioFormat {
+	case FormatPCM:
+		if w.BitDepth == 0 || w.BitDepth > 32 {
+			return fmt.Errorf("wavio: unsupported PCM bit depth %d", w.BitDepth)
+		}
+		// Odd depths such as 20 bits are stored in whole bytes.
+		w.BitDepth = (w.BitDepth + 7) / 8 * 8
+	case FormatIEEEFloat:
+		if w.BitDepth != 32 && w.BitDepth != 64 {
+			return fmt.Errorf("wavio: unsupported float bit depth %d", w.BitDepth)
+		}
+	default:
+		return fmt.Errorf("wavio: unsupported audio format 0x%04x", w.WavAudioFormat)
+	}
+	return nil
+}
+
+// skipPad consumes the pad byte after an odd sized chunk.  Some writers
+// leave it out, so it is only consumed when it is actually zero.
+func (w *Reader) skipPad(size uint32) {
+	if size&1 == 0 {
+		return
+	}
+	if b, err := w.r.Peek(1); err == nil && b[0] == 0 {
+		w.r.ReadByte()
+	}
+}
+
+func (w *Reader) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, w.r, n)
+	return err
+}
+
+// Format returns the channel count and sample rate.
+func (w *Reader) Format() *audio.Format {
+	return &audio.Format{NumChannels: int(w.NumChans), SampleRate: int(w.SampleRate)}
+}
+
+// frameSize is the number of bytes in one frame.
+func (w *Reader) frameSize() int {
+	return int(w.NumChans) * int(w.BitDepth/8)
+}
+
+// Frames is the number of frames in the data chunk or -1 when unknown.
+func (w *Reader) Frames() int64 {
+	if w.DataSize < 0 {
+		return -1
+	}
+	return w.DataSize / int64(w.frameSize())
+}
+
+// Duration is the length of the audio according to the header.
+func (w *Reader) Duration() (time.Duration, error) {
+	frames := w.Frames()
+	if frames < 0 {
+		return 0, errors.New("wavio: data size unknown")
+	}
+	return time.Duration(float64(frames) / float64(w.SampleRate) * float64(time.Second)), nil
+}
+
+// PCMBuffer fills buf.Data with as many whole frames as fit and returns the
+// number of samples read.  It returns 0 and a nil error at the end of the
+// data.  Integer samples keep their stored value; float samples are scaled
+// to the integer range of BitDepth so callers can treat both alike.
+func (w *Reader) PCMBuffer(buf *audio.IntBuffer) (int, error) {
+	raw, err := w.read(len(buf.Data) / int(w.NumChans))
+	if err != nil {
+		return 0, err
+	}
+	bytesPerSample := int(w.BitDepth / 8)
+	n := len(raw) / bytesPerSample
+	for i := 0; i < n; i++ {
+		buf.Data[i] = w.decode(raw[i*bytesPerSample : (i+1)*bytesPerSample])
+	}
+	if buf.Format == nil {
+		buf.Format = w.Format()
+	}
+	buf.SourceBitDepth = int(w.BitDepth)
+	return n, nil
+}
+
+// ReadFloats fills data with as many whole frames as fit and returns the
+// number of samples read, with 1.0 as full scale.  Float samples are
+// returned exactly as stored.  It returns 0 and a nil error at the end of
+// the data.
+func (w *Reader) ReadFloats(data []float64) (int, error) {
+	raw, err := w.read(len(data) / int(w.NumChans))
+	if err != nil {
+		return 0, err
+	}
+	bytesPerSample := int(w.BitDepth / 8)
+	n := len(raw) / bytesPerSample
+	scale := math.Pow(2, float64(w.BitDepth-1))
+	for i := 0; i < n; i++ {
+		b := raw[i*bytesPerSample : (i+1)*bytesPerSample]
+		switch {
+		case w.WavAudioFormat != FormatIEEEFloat:
+			data[i] = float64(w.decode(b)) / scale
+		case w.BitDepth == 32:
+			data[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		default:
+			data[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	}
+	return n, nil
+}
+
+// ReadTrailingChunks skips any samples left unread and adds the chunks
+// that follow the data chunk, such as LIST or id3 tags written at the end,
+// to Chunks.  When the data size is unknown the data runs to EOF and there
+// is nothing after it.  A truncated last chunk is still listed.
+func (w *Reader) ReadTrailingChunks() error {
+	if w.DataSize < 0 {
+		return nil
+	}
+	if err := w.skip(w.remaining); err != nil {
+		return nil
+	}
+	w.remaining = 0
+	w.skipPad(uint32(w.DataSize))
+	for {
+		id, size, err := w.nextChunk()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.Chunks = append(w.Chunks, Chunk{ID: id, Size: size})
+		if err := w.skip(int64(size)); err != nil {
+			return nil
+		}
+		w.skipPad(size)
+	}
+}
+
+// read returns the raw bytes of up to frames whole frames.
+func (w *Reader) read(frames int) ([]byte, error) {
+	frameSize := w.frameSize()
+	want := int64(frames * frameSize)
+	if w.remaining >= 0 && want > w.remaining {
+		want = w.remaining - w.remaining%int64(frameSize)
+	}
+	if want == 0 {
+		return nil, nil
+	}
+	if int64(cap(w.raw)) < want {
+		w.raw = make([]byte, want)
+	}
+	raw := w.raw[:want]
+	got, err := io.ReadFull(w.r, raw)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if err != nil {
+		// The header promised more data than the file holds.
+		w.remaining = 0
+	} else if w.remaining >= 0 {
+		w.remaining -= int64(got)
+	}
+	return raw[:got-got%frameSize], nil
+}
+
+func (w *Reader) decode(b []byte) int {
+	if w.WavAudioFormat == FormatIEEEFloat {
+		var f float64
+		if w.BitDepth == 32 {
+			f = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		} else {
+			f = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		scale := math.Pow(2, float64(w.BitDepth-1))
+		f = math.Max(-scale, math.Min(f*scale, math.Nextafter(scale, 0)))
+		return int(f)
+	}
+	switch len(b) {
+	case 1:
+		// 8 bit WAV is unsigned
+		return int(b[0]) - 128
+	case 2:
+		return int(int16(binary.LittleEndian.Uint16(b)))
+	case 3:
+		return int(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+	default:
+		return int(int32(binary.LittleEndian.Uint32(b)))
+	}
+}
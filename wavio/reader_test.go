@@ -0,0 +1,169 @@
+package wavio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/go-audio/audio"
+)
+
+func chunk(id string, size uint32, body []byte) []byte {
+	b := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[4:], size)
+	return append(b, body...)
+}
+
+func fmtBody(format, channels uint16, rate uint32, bits uint16) []byte {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint16(b[0:], format)
+	binary.LittleEndian.PutUint16(b[2:], channels)
+	binary.LittleEndian.PutUint32(b[4:], rate)
+	binary.LittleEndian.PutUint32(b[8:], rate*uint32(channels*bits/8))
+	binary.LittleEndian.PutUint16(b[12:], channels*bits/8)
+	binary.LittleEndian.PutUint16(b[14:], bits)
+	return b
+}
+
+func extensibleBody(subFormat, channels uint16, rate uint32, bits uint16) []byte {
+	b := fmtBody(FormatExtensible, channels, rate, bits)
+	ext := make([]byte, 24)
+	binary.LittleEndian.PutUint16(ext[0:], 22)
+	binary.LittleEndian.PutUint16(ext[2:], bits)
+	binary.LittleEndian.PutUint16(ext[8:], subFormat)
+	return append(b, ext...)
+}
+
+func riff(chunks ...[]byte) []byte {
+	b := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func int16s(v ...int16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, v)
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, data []byte) (*Reader, []int) {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []int
+	buf := &audio.IntBuffer{Data: make([]int, 3)}
+	for {
+		n, err := r.PCMBuffer(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return r, out
+		}
+		out = append(out, buf.Data[:n]...)
+	}
+}
+
+func TestReader(t *testing.T) {
+	samples := int16s(1, -1, 2, -2, 3, -3)
+	want := []int{1, -1, 2, -2, 3, -3}
+	tests := []struct {
+		name string
+		data []byte
+		want []int
+	}{
+		{"plain", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("data", 12, samples)), want},
+		{"streamed data size", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("data", maxChunkSize, samples)), want},
+		{"zero data size", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("data", 0, samples)), want},
+		{"data size past EOF", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("data", 4000, samples)), want},
+		{"partial frame", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("data", 14, append(samples, 4, 0))), want},
+		{"long fmt", riff(chunk("fmt ", 18, append(fmtBody(FormatPCM, 2, 16000, 16), 0, 0)), chunk("data", 12, samples)), want},
+		{"unpadded odd chunk", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("LIST", 3, []byte("abc")), chunk("data", 12, samples)), want},
+		{"padded odd chunk", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 2, 16000, 16)), chunk("LIST", 3, []byte("abc\x00")), chunk("data", 12, samples)), want},
+		{"extensible", riff(chunk("fmt ", 40, extensibleBody(FormatPCM, 2, 16000, 16)), chunk("data", 12, samples)), want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, got := readAll(t, tt.data)
+			if r.NumChans != 2 || r.SampleRate != 16000 || r.BitDepth != 16 || r.WavAudioFormat != FormatPCM {
+				t.Errorf("format = %d ch %d Hz %d bit format %d", r.NumChans, r.SampleRate, r.BitDepth, r.WavAudioFormat)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderFormats(t *testing.T) {
+	float := make([]byte, 8)
+	binary.LittleEndian.PutUint32(float[0:], math.Float32bits(0.5))
+	binary.LittleEndian.PutUint32(float[4:], math.Float32bits(-1.0))
+	tests := []struct {
+		name string
+		data []byte
+		want []int
+	}{
+		{"8 bit", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 1, 8000, 8)), chunk("data", 2, []byte{0, 255})), []int{-128, 127}},
+		{"24 bit", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 1, 8000, 24)), chunk("data", 6, []byte{0xff, 0xff, 0xff, 0x00, 0x00, 0x40})), []int{-1, 1 << 22}},
+		{"float", riff(chunk("fmt ", 16, fmtBody(FormatIEEEFloat, 1, 8000, 32)), chunk("data", 8, float)), []int{1 << 30, -(1 << 31)}},
+		{"extensible float", riff(chunk("fmt ", 40, extensibleBody(FormatIEEEFloat, 1, 8000, 32)), chunk("data", 8, float)), []int{1 << 30, -(1 << 31)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := readAll(t, tt.data)
+			if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WAVE")},
+		{"no data", riff(chunk("fmt ", 16, fmtBody(FormatPCM, 1, 8000, 16)))},
+		{"data before fmt", riff(chunk("data", 2, []byte{0, 0}), chunk("fmt ", 16, fmtBody(FormatPCM, 1, 8000, 16)))},
+		{"mu-law", riff(chunk("fmt ", 16, fmtBody(7, 1, 8000, 8)), chunk("data", 2, []byte{0, 0}))},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestReadTrailingChunks(t *testing.T) {
+	data := riff(chunk("fmt ", 16, fmtBody(FormatPCM, 1, 8000, 8)), chunk("data", 3, []byte{1, 2, 3, 0}),
+		chunk("LIST", 4, []byte("INFO")), chunk("id3 ", 10, []byte("abc")))
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leave samples unread, they are skipped
+	if err := r.ReadTrailingChunks(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Chunk{{"fmt ", 16}, {"data", 3}, {"LIST", 4}, {"id3 ", 10}}
+	if len(r.Chunks) != len(want) {
+		t.Fatalf("chunks = %v, want %v", r.Chunks, want)
+	}
+	for i := range want {
+		if r.Chunks[i] != want[i] {
+			t.Fatalf("chunks = %v, want %v", r.Chunks, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package wavio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Writer writes PCM or IEEE float WAV files.  The header is written up
+// front with empty sizes which are filled in by Close, so the destination
+// has to be seekable.
+type Writer struct {
+	SampleRate int
+	BitDepth   int
+	NumChans   int
+	Format     uint16
+
+	w        io.WriteSeeker
+	dataSize int64
+	factPos  int64
+	raw      []byte
+}
+
+// NewWriter writes the header for a file with the given layout.  Format is
+// FormatPCM for 8, 16, 24 or 32 bit integers or FormatIEEEFloat for 32 or
+// 64 bit floats.
+func NewWriter(w io.WriteSeeker, sampleRate, bitDepth, numChans int, format uint16) (*Writer, error) {
+	switch {
+	case format == FormatPCM && (bitDepth == 8 || bitDepth == 16 || bitDepth == 24 || bitDepth == 32):
+	case format == FormatIEEEFloat && (bitDepth == 32 || bitDepth == 64):
+	default:
+		return nil, fmt.Errorf("wavio: can't write %d bit samples in format 0x%04x", bitDepth, format)
+	}
+	if numChans < 1 {
+		return nil, fmt.Errorf("wavio: can't write %d channels", numChans)
+	}
+	wr := &Writer{SampleRate: sampleRate, BitDepth: bitDepth, NumChans: numChans, Format: format, w: w}
+
+	blockAlign := numChans * bitDepth / 8
+	var hdr bytes.Buffer
+	hdr.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	fields := []interface{}{
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),
+		format,
+		uint16(numChans),
+		uint32(sampleRate),
+		uint32(sampleRate * blockAlign),
+		uint16(blockAlign),
+		uint16(bitDepth),
+	}
+	if format == FormatIEEEFloat {
+		// non-PCM formats carry a cbSize field and a fact chunk
+		fields[1] = uint32(18)
+		fields = append(fields, uint16(0), [4]byte{'f', 'a', 'c', 't'}, uint32(4), uint32(0))
+	}
+	for _, f := range fields {
+		binary.Write(&hdr, binary.LittleEndian, f)
+	}
+	if format == FormatIEEEFloat {
+		wr.factPos = int64(hdr.Len() - 4)
+	}
+	hdr.WriteString("data\x00\x00\x00\x00")
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func (w *Writer) headerSize() int64 {
+	if w.Format == FormatIEEEFloat {
+		return 58
+	}
+	return 44
+}
+
+// WriteInts writes interleaved integer samples in the range of BitDepth.
+// It can only be used with FormatPCM.
+func (w *Writer) WriteInts(data []int) error {
+	if w.Format != FormatPCM {
+		return fmt.Errorf("wavio: WriteInts on a float file")
+	}
+	bytesPerSample := w.BitDepth / 8
+	raw := w.buffer(len(data) * bytesPerSample)
+	for i, v := range data {
+		b := raw[i*bytesPerSample:]
+		switch bytesPerSample {
+		case 1:
+			// 8 bit WAV is unsigned
+			b[0] = byte(v + 128)
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(int16(v)))
+		case 3:
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		default:
+			binary.LittleEndian.PutUint32(b, uint32(int32(v)))
+		}
+	}
+	return w.write(raw)
+}
+
+// WriteFloats writes interleaved samples where 1.0 is full scale.  Float
+// files store them as is; PCM files round and clip them to BitDepth.
+func (w *Writer) WriteFloats(data []float64) error {
+	if w.Format == FormatPCM {
+		scale := math.Pow(2, float64(w.BitDepth-1))
+		ints := make([]int, len(data))
+		for i, v := range data {
+			ints[i] = int(math.Max(-scale, math.Min(scale-1, math.Round(v*scale))))
+		}
+		return w.WriteInts(ints)
+	}
+	bytesPerSample := w.BitDepth / 8
+	raw := w.buffer(len(data) * bytesPerSample)
+	for i, v := range data {
+		if bytesPerSample == 4 {
+			binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(float32(v)))
+		} else {
+			binary.LittleEndian.PutUint64(raw[i*8:], math.Float64bits(v))
+		}
+	}
+	return w.write(raw)
+}
+
+func (w *Writer) buffer(n int) []byte {
+	if cap(w.raw) < n {
+		w.raw = make([]byte, n)
+	}
+	return w.raw[:n]
+}
+
+func (w *Writer) write(raw []byte) error {
+	n, err := w.w.Write(raw)
+	w.dataSize += int64(n)
+	return err
+}
+
+// Close pads the data chunk and fills in the sizes in the header.  It does
+// not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.dataSize%2 == 1 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	riffSize := w.headerSize() - 8 + w.dataSize + w.dataSize%2
+	patches := []struct {
+		pos   int64
+		value uint32
+	}{
+		{4, uint32(riffSize)},
+		{w.headerSize() - 4, uint32(w.dataSize)},
+	}
+	if w.factPos != 0 {
+		frames := w.dataSize / int64(w.NumChans*w.BitDepth/8)
+		patches = append(patches, struct {
+			pos   int64
+			value uint32
+		}{w.factPos, uint32(frames)})
+	}
+	var b [4]byte
+	for _, p := range patches {
+		if _, err := w.w.Seek(p.pos, io.SeekStart); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(b[:], p.value)
+		if _, err := w.w.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
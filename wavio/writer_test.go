@@ -0,0 +1,128 @@
+package wavio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+// seekBuffer is an in memory io.WriteSeeker.
+type seekBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if end := s.pos + len(p); end > len(s.data) {
+		s.data = append(s.data, make([]byte, end-len(s.data))...)
+	}
+	n := copy(s.data[s.pos:], p)
+	s.pos += n
+	return n, nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.pos = int(offset)
+	case io.SeekCurrent:
+		s.pos += int(offset)
+	case io.SeekEnd:
+		s.pos = len(s.data) + int(offset)
+	}
+	if s.pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	return int64(s.pos), nil
+}
+
+func TestWriterInts(t *testing.T) {
+	for _, bits := range []int{8, 16, 24, 32} {
+		max := 1<<uint(bits-1) - 1
+		samples := []int{0, 1, -1, max, -max - 1, max / 3}
+		var buf seekBuffer
+		w, err := NewWriter(&buf, 44100, bits, 2, FormatPCM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteInts(samples); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, got := readAll(t, buf.data)
+		if r.SampleRate != 44100 || r.NumChans != 2 || int(r.BitDepth) != bits || r.DataSize != int64(len(samples)*bits/8) {
+			t.Errorf("%d bit: header = %+v", bits, r)
+		}
+		if len(got) != len(samples) {
+			t.Fatalf("%d bit: got %v, want %v", bits, got, samples)
+		}
+		for i := range samples {
+			if got[i] != samples[i] {
+				t.Errorf("%d bit: got %v, want %v", bits, got, samples)
+				break
+			}
+		}
+	}
+}
+
+func TestWriterFloats(t *testing.T) {
+	samples := []float64{0, 0.5, -1, 1.0 / 3}
+	for _, bits := range []int{32, 64} {
+		var buf seekBuffer
+		w, err := NewWriter(&buf, 48000, bits, 1, FormatIEEEFloat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteFloats(samples)
+		w.Close()
+
+		r, err := NewReader(bytes.NewReader(buf.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.WavAudioFormat != FormatIEEEFloat || int(r.BitDepth) != bits || r.Frames() != int64(len(samples)) {
+			t.Errorf("%d bit: header = %+v", bits, r)
+		}
+		got := make([]float64, 8)
+		n, err := r.ReadFloats(got)
+		if err != nil || n != len(samples) {
+			t.Fatalf("%d bit: read %d samples, %v", bits, n, err)
+		}
+		for i, want := range samples {
+			if bits == 32 {
+				want = float64(float32(want))
+			}
+			if got[i] != want {
+				t.Errorf("%d bit: sample %d = %v, want %v", bits, i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestWriterPads(t *testing.T) {
+	var buf seekBuffer
+	w, _ := NewWriter(&buf, 8000, 8, 1, FormatPCM)
+	w.WriteFloats([]float64{0.5, math.Inf(1), -2})
+	w.Close()
+	if len(buf.data) != 44+4 {
+		t.Fatalf("file is %d bytes, want 48", len(buf.data))
+	}
+	_, got := readAll(t, buf.data)
+	if want := []int{64, 127, -128}; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	var buf seekBuffer
+	if _, err := NewWriter(&buf, 44100, 20, 1, FormatPCM); err == nil {
+		t.Error("20 bit PCM: no error")
+	}
+	if _, err := NewWriter(&buf, 44100, 16, 1, FormatIEEEFloat); err == nil {
+		t.Error("16 bit float: no error")
+	}
+}